@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/monitor"
+)
+
+type StatusError struct {
+	Url     string
+	Code    int
+	Status  string
+	Message string
+	Reasons map[string]string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "unexpected status " + e.Status + " from " + e.Url + ": " + e.Message
+	}
+
+	return "unexpected status " + e.Status + " from " + e.Url
+}
+
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) GetAllSitesStatus(ctx context.Context) ([]*monitor.SiteStatus, error) {
+	var sites []*monitor.SiteStatus
+
+	if err := c.get(ctx, "/api/sites", &sites); err != nil {
+		return nil, err
+	}
+
+	return sites, nil
+}
+
+func (c *Client) GetAvailableSite(ctx context.Context, opts monitor.SelectOptions) (*monitor.SiteStatus, error) {
+	var site monitor.SiteStatus
+
+	query := url.Values{}
+	query.Set("degraded", strconv.FormatBool(opts.AllowDegraded))
+	if opts.Session != "" {
+		query.Set("session", opts.Session)
+	}
+
+	if err := c.get(ctx, selectPath(opts)+"?"+query.Encode(), &site); err != nil {
+		return nil, err
+	}
+
+	return &site, nil
+}
+
+func (c *Client) GetRankedSites(ctx context.Context, n int, opts monitor.SelectOptions) ([]*monitor.SiteStatus, error) {
+	var sites []*monitor.SiteStatus
+
+	query := url.Values{}
+	query.Set("top", strconv.Itoa(n))
+	if opts.Session != "" {
+		query.Set("session", opts.Session)
+	}
+
+	if err := c.get(ctx, selectPath(opts)+"?"+query.Encode(), &sites); err != nil {
+		return nil, err
+	}
+
+	return sites, nil
+}
+
+func selectPath(opts monitor.SelectOptions) string {
+	if opts.Group != "" {
+		return "/api/groups/" + url.PathEscape(opts.Group) + "/select"
+	}
+
+	return "/api/select"
+}
+
+func (c *Client) GetSiteQueues(ctx context.Context, name string) (*monitor.Queue, error) {
+	var queue monitor.Queue
+
+	if err := c.get(ctx, "/api/sites/"+url.PathEscape(name)+"/queues", &queue); err != nil {
+		return nil, err
+	}
+
+	return &queue, nil
+}
+
+func (c *Client) get(ctx context.Context, _path string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+_path, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error   string            `json:"error"`
+			Reasons map[string]string `json:"reasons"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return &StatusError{
+			Url:     c.url + _path,
+			Code:    resp.StatusCode,
+			Status:  resp.Status,
+			Message: strings.TrimSpace(body.Error),
+			Reasons: body.Reasons,
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(data); err != nil {
+		return errors.Wrap(err, "failed to decode response\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/repo-scm/proxy/monitor"
+)
+
+// newServer answers with the sites, and records the path and query of each request.
+func newServer(t *testing.T, requests *[]string) *httptest.Server {
+	t.Helper()
+
+	sites := []*monitor.SiteStatus{
+		{Name: "gerrit-beijing", Host: "10.67.16.29", Healthy: true},
+		{Name: "gerrit-shanghai", Host: "10.63.237.206", Healthy: true},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RequestURI())
+		switch {
+		case r.URL.Path == "/api/sites":
+			_ = json.NewEncoder(w).Encode(sites)
+		case strings.HasSuffix(r.URL.Path, "/select") && r.URL.Query().Has("top"):
+			_ = json.NewEncoder(w).Encode(sites)
+		case r.URL.Path == "/api/select":
+			_ = json.NewEncoder(w).Encode(sites[0])
+		case r.URL.Path == "/api/sites/gerrit-beijing/queues":
+			_ = json.NewEncoder(w).Encode(&monitor.Queue{Size: 3})
+		case r.URL.Path == "/api/groups/kernel/select":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "no healthy site\n"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestGetSites(t *testing.T) {
+	var requests []string
+
+	c := NewClient(newServer(t, &requests).URL + "/")
+
+	sites, err := c.GetAllSitesStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sites) != 2 || sites[0].Name != "gerrit-beijing" {
+		t.Errorf("sites = %v, want gerrit-beijing and gerrit-shanghai", sites)
+	}
+
+	site, err := c.GetAvailableSite(context.Background(), monitor.SelectOptions{AllowDegraded: true, Session: "job 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "gerrit-beijing" {
+		t.Errorf("site = %s, want gerrit-beijing", site.Name)
+	}
+
+	if sites, err = c.GetRankedSites(context.Background(), 2, monitor.SelectOptions{Group: "android"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sites) != 2 {
+		t.Errorf("ranked sites = %d, want 2", len(sites))
+	}
+
+	queue, err := c.GetSiteQueues(context.Background(), "gerrit-beijing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queue.Size != 3 {
+		t.Errorf("queue size = %d, want 3", queue.Size)
+	}
+
+	want := []string{
+		"/api/sites",
+		"/api/select?degraded=true&session=job+1",
+		"/api/groups/android/select?top=2",
+		"/api/sites/gerrit-beijing/queues",
+	}
+
+	if strings.Join(requests, " ") != strings.Join(want, " ") {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	var requests []string
+
+	ts := newServer(t, &requests)
+	c := NewClient(ts.URL)
+
+	_, err := c.GetAvailableSite(context.Background(), monitor.SelectOptions{Group: "kernel"})
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error = %v, want StatusError", err)
+	}
+
+	if statusErr.Code != http.StatusServiceUnavailable || statusErr.Message != "no healthy site" {
+		t.Errorf("status error = %+v, want 503 no healthy site", statusErr)
+	}
+
+	if want := "unexpected status 503 Service Unavailable from " + ts.URL + "/api/groups/kernel/select?degraded=false: no healthy site"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+
+	_, err = c.GetSiteQueues(context.Background(), "gerrit-xian")
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound || statusErr.Message != "" {
+		t.Errorf("unknown site error = %v, want 404 without message", err)
+	}
+}
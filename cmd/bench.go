@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/tw"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	benchPort = 29418
+)
+
+var (
+	benchFormat   string
+	benchInterval time.Duration
+	benchKey      string
+	benchRounds   int
+	benchScenario string
+	benchSites    string
+	benchUser     string
+)
+
+type benchSite struct {
+	Name    string  `json:"name"`
+	Host    string  `json:"host"`
+	Success int     `json:"success"`
+	Rounds  int     `json:"rounds"`
+	P50     int64   `json:"p50"`
+	P90     int64   `json:"p90"`
+	P99     int64   `json:"p99"`
+	Max     int64   `json:"max"`
+	Score   float64 `json:"score"`
+	Stddev  float64 `json:"stddev"`
+	Wins    int     `json:"wins"`
+
+	latencies []int64
+	scores    []int
+}
+
+type benchResult struct {
+	Best      string       `json:"best"`
+	Stability float64      `json:"stability"`
+	Changes   int          `json:"changes"`
+	Winners   []string     `json:"winners"`
+	Sites     []*benchSite `json:"sites"`
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark site selection over repeated probes",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := utils.ValidFormat(benchFormat); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err := runBench(ctx, config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.PersistentFlags().StringVarP(&benchFormat, "format", "f", utils.FormatTable, "output format (table|json|yaml|csv|env|template)")
+	benchCmd.PersistentFlags().DurationVarP(&benchInterval, "interval", "i", time.Second, "interval between rounds")
+	benchCmd.PersistentFlags().StringVar(&benchKey, "key", "", "ssh key of hosts not in config (default ssh agent)")
+	benchCmd.PersistentFlags().IntVarP(&benchRounds, "rounds", "n", 10, "number of rounds")
+	benchCmd.PersistentFlags().StringVar(&benchScenario, "scenario", "", "simulate sites with scenario file")
+	benchCmd.PersistentFlags().StringVarP(&benchSites, "sites", "s", "", "comma separated site names or hosts (default all sites)")
+	benchCmd.PersistentFlags().StringVar(&benchUser, "user", os.Getenv("USER"), "ssh user of hosts not in config")
+}
+
+func runBench(ctx context.Context, cfg *config.Config) error {
+	if benchRounds <= 0 {
+		return errors.New("invalid rounds\n")
+	}
+
+	var scenario *monitor.Scenario
+
+	if benchScenario != "" {
+		var err error
+		if scenario, err = monitor.LoadScenario(benchScenario); err != nil {
+			return err
+		}
+		cfg.Gerrits = map[string]config.Gerrit{}
+		scenario.Apply(cfg)
+	}
+
+	bench, err := benchConfig(cfg, benchSites)
+	if err != nil {
+		return err
+	}
+
+	m := monitor.NewMonitor(bench)
+	if scenario != nil {
+		m = monitor.NewSimulationMonitor(bench, scenario)
+	}
+
+	sites := map[string]*benchSite{}
+	for name, site := range bench.Gerrits {
+		sites[name] = &benchSite{Name: name, Host: site.Ssh.Host}
+	}
+
+	result := &benchResult{}
+
+	for round := 0; round < benchRounds; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(benchInterval):
+			}
+		}
+
+		ranked, err := m.GetRankedSites(ctx, 0, monitor.SelectOptions{Peek: true})
+		if err != nil {
+			return err
+		}
+
+		for _, status := range ranked {
+			site := sites[status.Name]
+			site.Rounds++
+			if status.Healthy {
+				site.Success++
+				site.latencies = append(site.latencies, status.ResponseTime)
+				site.scores = append(site.scores, status.Score)
+			}
+		}
+
+		winner := ""
+		if len(ranked) != 0 && ranked[0].Healthy {
+			winner = ranked[0].Name
+			sites[winner].Wins++
+		}
+
+		if round > 0 && winner != result.Winners[round-1] {
+			result.Changes++
+		}
+
+		result.Winners = append(result.Winners, winner)
+	}
+
+	for _, site := range sites {
+		site.summarize()
+		result.Sites = append(result.Sites, site)
+		if site.Wins > 0 && (result.Best == "" || site.Wins > sites[result.Best].Wins ||
+			site.Wins == sites[result.Best].Wins && site.Name < result.Best) {
+			result.Best = site.Name
+		}
+	}
+
+	sort.Slice(result.Sites, func(i, j int) bool {
+		return result.Sites[i].Name < result.Sites[j].Name
+	})
+
+	if result.Best != "" {
+		result.Stability = float64(sites[result.Best].Wins) / float64(benchRounds)
+	}
+
+	return writeBench(ctx, os.Stdout, benchFormat, result)
+}
+
+// benchConfig limits the config to the given sites, which are either names of configured
+// sites or hosts reached with the ssh user and key of the flags.
+func benchConfig(cfg *config.Config, names string) (*config.Config, error) {
+	bench := *cfg
+	bench.Gerrits = map[string]config.Gerrit{}
+
+	if names == "" {
+		for name, site := range cfg.Gerrits {
+			bench.Gerrits[name] = site
+		}
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if site, found := cfg.Gerrits[name]; found {
+			bench.Gerrits[name] = site
+			continue
+		}
+		bench.Gerrits[name] = config.Gerrit{
+			Location: "-",
+			Ssh: config.Ssh{
+				Host: name,
+				Port: benchPort,
+				User: benchUser,
+				Key:  config.KeyFile(benchKey),
+			},
+		}
+	}
+
+	if len(bench.Gerrits) == 0 {
+		return nil, errors.New("no sites to benchmark\n")
+	}
+
+	return &bench, nil
+}
+
+func (s *benchSite) summarize() {
+	sort.Slice(s.latencies, func(i, j int) bool {
+		return s.latencies[i] < s.latencies[j]
+	})
+
+	s.P50 = percentile(s.latencies, 50)
+	s.P90 = percentile(s.latencies, 90)
+	s.P99 = percentile(s.latencies, 99)
+	s.Max = percentile(s.latencies, 100)
+
+	if len(s.scores) == 0 {
+		return
+	}
+
+	var sum float64
+	for _, score := range s.scores {
+		sum += float64(score)
+	}
+
+	s.Score = sum / float64(len(s.scores))
+
+	var variance float64
+	for _, score := range s.scores {
+		variance += (float64(score) - s.Score) * (float64(score) - s.Score)
+	}
+
+	s.Stddev = math.Sqrt(variance / float64(len(s.scores)))
+}
+
+// percentile returns the nearest-rank percentile of sorted values, or -1 if there are none.
+func percentile(values []int64, p int) int64 {
+	if len(values) == 0 {
+		return -1
+	}
+
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(values))))
+
+	return values[max(rank, 1)-1]
+}
+
+func writeBench(ctx context.Context, w io.Writer, format string, result *benchResult) error {
+	data := &utils.Format{
+		Header: []string{"NAME", "HOST", "SUCCESS", "P50", "P90", "P99", "MAX", "SCORE", "STDDEV", "WINS"},
+		Env: [][]string{
+			{"BENCH_BEST", result.Best},
+			{"BENCH_STABILITY", fmt.Sprintf("%.2f", result.Stability)},
+			{"BENCH_CHANGES", fmt.Sprintf("%d", result.Changes)},
+		},
+		Data: result,
+	}
+
+	latency := func(value int64) string {
+		if value < 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%dms", value)
+	}
+
+	for _, site := range result.Sites {
+		data.Rows = append(data.Rows, []string{
+			site.Name,
+			site.Host,
+			fmt.Sprintf("%d/%d", site.Success, site.Rounds),
+			latency(site.P50),
+			latency(site.P90),
+			latency(site.P99),
+			latency(site.Max),
+			fmt.Sprintf("%.1f", site.Score),
+			fmt.Sprintf("%.1f", site.Stddev),
+			fmt.Sprintf("%d", site.Wins),
+		})
+	}
+
+	if format != utils.FormatTable {
+		if err := utils.WriteFormat(ctx, w, format, data); err != nil {
+			return errors.Wrap(err, "failed to write bench\n")
+		}
+		return nil
+	}
+
+	// Auto format of headers would split the percentiles, e.g. "P50" to "P 50"
+	table := tablewriter.NewTable(w, tablewriter.WithHeaderAutoFormat(tw.Off))
+
+	table.Header(data.Header)
+	_ = table.Bulk(data.Rows)
+	if err := table.Render(); err != nil {
+		return errors.Wrap(err, "failed to write bench\n")
+	}
+
+	_, _ = fmt.Fprintf(w, "\nwinner stability: %.0f%% over %d rounds, %d changes\n", result.Stability*100, len(result.Winners), result.Changes)
+	_, _ = fmt.Fprintf(w, "best site: %s\n", result.Best)
+
+	return nil
+}
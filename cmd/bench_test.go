@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/utils"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []int64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+	for p, want := range map[int]int64{0: 10, 50: 50, 90: 90, 99: 100, 100: 100} {
+		if got := percentile(values, p); got != want {
+			t.Errorf("p%d = %d, want %d", p, got, want)
+		}
+	}
+
+	if got := percentile(nil, 50); got != -1 {
+		t.Errorf("p50 of none = %d, want -1", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	site := &benchSite{
+		latencies: []int64{30, 10, 20},
+		scores:    []int{10, 20, 30},
+	}
+
+	site.summarize()
+
+	if site.P50 != 20 || site.Max != 30 {
+		t.Errorf("p50 %d, max %d, want 20, 30", site.P50, site.Max)
+	}
+
+	if site.Score != 20 || math.Abs(site.Stddev-8.165) > 0.001 {
+		t.Errorf("score %.3f, stddev %.3f, want 20, 8.165", site.Score, site.Stddev)
+	}
+}
+
+func TestBenchConfig(t *testing.T) {
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"gerrit-beijing":  {Ssh: config.Ssh{Host: "10.67.16.29"}},
+			"gerrit-shanghai": {Ssh: config.Ssh{Host: "10.63.237.206"}},
+		},
+	}
+
+	bench, err := benchConfig(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bench.Gerrits) != 2 {
+		t.Errorf("all sites = %d sites, want 2", len(bench.Gerrits))
+	}
+
+	benchUser, benchKey = "alice", ""
+
+	bench, err = benchConfig(cfg, "gerrit-beijing, 10.95.243.159")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bench.Gerrits) != 2 || bench.Gerrits["gerrit-beijing"].Ssh.Host != "10.67.16.29" {
+		t.Errorf("sites = %v, want gerrit-beijing and 10.95.243.159", bench.Gerrits)
+	}
+
+	host := bench.Gerrits["10.95.243.159"].Ssh
+	if host.Host != "10.95.243.159" || host.Port != benchPort || host.User != "alice" || host.Key != "" {
+		t.Errorf("host = %+v, want port %d, user alice and no key", host, benchPort)
+	}
+
+	if _, err := benchConfig(cfg, " , "); err == nil {
+		t.Error("no sites = nil, want error")
+	}
+}
+
+func TestWriteBench(t *testing.T) {
+	site := &benchSite{Name: "gerrit-beijing", Host: "10.67.16.29", Success: 2, Rounds: 2, P50: 12, P90: 15, P99: 15, Max: 15, Wins: 2}
+	result := &benchResult{
+		Best:      "gerrit-beijing",
+		Stability: 1,
+		Winners:   []string{"gerrit-beijing", "gerrit-beijing"},
+		Sites:     []*benchSite{site, {Name: "gerrit-xian", Host: "10.95.243.159", Rounds: 2, P50: -1, P90: -1, P99: -1, Max: -1}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBench(context.Background(), &buf, utils.FormatTable, result); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"P50", "P99", "12ms", "2/2", "winner stability: 100% over 2 rounds, 0 changes\nbest site: gerrit-beijing\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table misses %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := writeBench(context.Background(), &buf, utils.FormatEnv, result); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "BENCH_BEST=gerrit-beijing\nBENCH_STABILITY=1.00\nBENCH_CHANGES=0\n"; buf.String() != want {
+		t.Errorf("env = %q, want %q", buf.String(), want)
+	}
+}
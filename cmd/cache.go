@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	cacheFile = "~/.repo-scm/proxy.cache"
+)
+
+type queryCache struct {
+	Key   string                `json:"key"`
+	Time  time.Time             `json:"time"`
+	Sites []*monitor.SiteStatus `json:"sites"`
+}
+
+func cachePath(name string) string {
+	if name == "" {
+		name = cacheFile
+	}
+
+	return utils.ExpandTilde(name)
+}
+
+func loadCache(name, key string, ttl time.Duration) []*monitor.SiteStatus {
+	var cache queryCache
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	buf, err := os.ReadFile(cachePath(name))
+	if err != nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(buf, &cache); err != nil {
+		return nil
+	}
+
+	if cache.Key != key || time.Since(cache.Time) > ttl || len(cache.Sites) == 0 {
+		return nil
+	}
+
+	return cache.Sites
+}
+
+func saveCache(name, key string, ttl time.Duration, sites []*monitor.SiteStatus) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	buf, err := json.Marshal(queryCache{
+		Key:   key,
+		Time:  time.Now(),
+		Sites: sites,
+	})
+	if err != nil {
+		return err
+	}
+
+	_path := cachePath(name)
+
+	if err := os.MkdirAll(path.Dir(_path), utils.PermDir); err != nil {
+		return err
+	}
+
+	return os.WriteFile(_path, buf, utils.PermFile)
+}
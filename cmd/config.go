@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+var (
+	effectiveConfig bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage config",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show config",
+	Run: func(cmd *cobra.Command, args []string) {
+		config := GetConfig()
+		if err := runConfigShow(config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.AddCommand(configShowCmd)
+
+	configShowCmd.PersistentFlags().BoolVarP(&effectiveConfig, "effective", "e", false,
+		"show the settings merged from config file, config directory, environment and --set, with their sources")
+}
+
+func runConfigShow(cfg *config.Config) error {
+	if !effectiveConfig {
+		buf, err := cfg.Show()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+
+	buf, err := cfg.Effective()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range cfg.Files {
+		fmt.Println("# merged from", name)
+	}
+
+	fmt.Print(string(buf))
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	envPrefix = "LOCAL_GERRIT"
+)
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func statusFormat(sites []*monitor.SiteStatus) *utils.Format {
+	data := &utils.Format{
+		Header: []string{"NAME", "LOCATION", "HOST", "URL", "HEALTHY", "RESPONSE", "CONNECTIONS", "QUEUE", "SCORE"},
+	}
+
+	for _, site := range sites {
+		data.Rows = append(data.Rows, []string{
+			site.Name,
+			site.Location,
+			site.Host,
+			site.Url,
+			fmt.Sprintf("%t", site.Healthy),
+			fmt.Sprintf("%dms", site.ResponseTime),
+			fmt.Sprintf("%d", site.Connections),
+			fmt.Sprintf("%d", site.QueueSize),
+			fmt.Sprintf("%d", site.Score),
+		})
+	}
+
+	if len(sites) != 0 {
+		data.Env = [][]string{
+			{envPrefix, sites[0].Host},
+			{envPrefix + "_NAME", sites[0].Name},
+			{envPrefix + "_URL", sites[0].Url},
+			{envPrefix + "_LOCATION", sites[0].Location},
+			{envPrefix + "_SCORE", fmt.Sprintf("%d", sites[0].Score)},
+		}
+	}
+
+	if len(sites) > 1 {
+		hosts := make([]string, 0, len(sites))
+		for _, site := range sites {
+			hosts = append(hosts, site.Host)
+		}
+		data.Env = append(data.Env, []string{envPrefix + "_RANKED", strings.Join(hosts, " ")})
+	}
+
+	if len(sites) == 1 {
+		data.Data = sites[0]
+	} else {
+		data.Data = sites
+	}
+
+	return data
+}
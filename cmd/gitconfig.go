@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/utils"
+)
+
+var (
+	gitScope string
+	gitFile  string
+	gitGroup string
+)
+
+var gitConfigCmd = &cobra.Command{
+	Use:   "git-config",
+	Short: "Rewrite git urls to available site",
+}
+
+var gitConfigApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply url.<site>.insteadOf entries",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := runGitConfigApply(ctx, config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var gitConfigRevertCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Revert url.<site>.insteadOf entries",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := runGitConfigRevert(ctx, config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(gitConfigCmd)
+
+	gitConfigCmd.AddCommand(gitConfigApplyCmd)
+	gitConfigCmd.AddCommand(gitConfigRevertCmd)
+
+	gitConfigCmd.PersistentFlags().StringVar(&gitScope, "scope", "global", "gitconfig scope (global|system|local|worktree)")
+	gitConfigCmd.PersistentFlags().StringVar(&gitFile, "file", "", "gitconfig file (overrides --scope)")
+
+	gitConfigApplyCmd.PersistentFlags().StringVarP(&gitGroup, "group", "g", "", "select among the sites of the cluster")
+}
+
+func runGitConfigApply(ctx context.Context, cfg *config.Config) error {
+	if err := validGitConfig(cfg); err != nil {
+		return err
+	}
+
+	site, err := selectSite(ctx, cfg, "", gitGroup)
+	if err != nil {
+		return err
+	}
+
+	var rules [][]string
+
+	gerrit := cfg.Gerrits[site.Name]
+
+	if cfg.Git.Http != "" && gerrit.Http.Url != "" {
+		rules = append(rules, []string{gerrit.Http.Url, cfg.Git.Http})
+	}
+
+	if cfg.Git.Ssh != "" && gerrit.Ssh.Host != "" {
+		host := gerrit.Ssh.Host
+		if gerrit.Ssh.User != "" {
+			host = gerrit.Ssh.User + "@" + host
+		}
+		rules = append(rules, []string{fmt.Sprintf("ssh://%s:%d", host, gerrit.Ssh.Port), cfg.Git.Ssh})
+	}
+
+	if err := revertGitConfig(ctx, cfg); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		base, canonical := withSlash(rule[0]), withSlash(rule[1])
+		if base == canonical {
+			continue
+		}
+		if _, err := runGit(ctx, gitScopeArgs("--add", "url."+base+".insteadOf", canonical)...); err != nil {
+			return err
+		}
+		fmt.Printf("url.%s.insteadOf %s\n", base, canonical)
+	}
+
+	return nil
+}
+
+func runGitConfigRevert(ctx context.Context, cfg *config.Config) error {
+	if err := validGitConfig(cfg); err != nil {
+		return err
+	}
+
+	return revertGitConfig(ctx, cfg)
+}
+
+func validGitConfig(cfg *config.Config) error {
+	if cfg.Git.Http == "" && cfg.Git.Ssh == "" {
+		return errors.New("git.http or git.ssh is required in config\n")
+	}
+
+	switch gitScope {
+	case "global", "system", "local", "worktree":
+		return nil
+	default:
+		return errors.Errorf("invalid scope %s (global|system|local|worktree)", gitScope)
+	}
+}
+
+func revertGitConfig(ctx context.Context, cfg *config.Config) error {
+	canonicals := map[string]bool{}
+
+	for _, item := range []string{cfg.Git.Http, cfg.Git.Ssh} {
+		if item != "" {
+			canonicals[withSlash(item)] = true
+		}
+	}
+
+	entries, err := gitInsteadOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	removed := map[string]bool{}
+
+	for _, entry := range entries {
+		if !canonicals[entry[1]] {
+			continue
+		}
+		// Match the value as a regexp rather than with --fixed-value, which requires git 2.30
+		if _, err := runGit(ctx, gitScopeArgs("--unset-all", "url."+entry[0]+".insteadOf", "^"+regexp.QuoteMeta(entry[1])+"$")...); err != nil {
+			return err
+		}
+		removed[entry[0]] = true
+		fmt.Printf("removed url.%s.insteadOf %s\n", entry[0], entry[1])
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	output, _ := runGit(ctx, gitScopeArgs("--name-only", "--get-regexp", `^url\.`)...)
+	for _, line := range strings.Split(output, "\n") {
+		if index := strings.LastIndex(line, "."); index > len("url.") {
+			delete(removed, line[len("url."):index])
+		}
+	}
+
+	for base := range removed {
+		_, _ = runGit(ctx, gitScopeArgs("--remove-section", "url."+base)...)
+	}
+
+	return nil
+}
+
+func gitInsteadOf(ctx context.Context) ([][]string, error) {
+	var entries [][]string
+
+	output, err := runGit(ctx, gitScopeArgs("--get-regexp", `^url\..*\.insteadof$`)...)
+	if err != nil {
+		var exitErr *exec.ExitError
+		// Exit status 1 means no matching entries
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), " ")
+		if !found {
+			continue
+		}
+		index := strings.LastIndex(key, ".")
+		entries = append(entries, []string{key[len("url."):index], value})
+	}
+
+	return entries, nil
+}
+
+func gitScopeArgs(args ...string) []string {
+	if gitFile != "" {
+		return append([]string{"--file", utils.ExpandTilde(gitFile)}, args...)
+	}
+
+	return append([]string{"--" + gitScope}, args...)
+}
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"config"}, args...)...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	slog.Debug("git", "command", strings.Join(cmd.Args, " "), "error", err)
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Wrap(err, msg)
+		}
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+func withSlash(url string) string {
+	if strings.HasSuffix(url, "/") {
+		return url
+	}
+
+	return url + "/"
+}
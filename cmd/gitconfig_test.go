@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+)
+
+func TestGitConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&monitor.SiteStatus{Name: "gerrit-beijing", Host: "10.67.16.29", Healthy: true})
+	}))
+	defer ts.Close()
+
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"gerrit-beijing": {
+				Http: config.Http{Url: "https://gerrit-beijing.com"},
+				Ssh:  config.Ssh{Host: "10.67.16.29", Port: 29418, User: "ci-bot"},
+			},
+		},
+		Git: config.Git{
+			Http: "https://gerrit.example.com",
+			Ssh:  "ssh://gerrit.example.com:29418",
+		},
+		Server: config.Server{Url: ts.URL},
+	}
+
+	gitFile = filepath.Join(t.TempDir(), "gitconfig")
+	defer func() {
+		gitFile = ""
+	}()
+
+	// Entries of other urls are kept
+	if err := os.WriteFile(gitFile, []byte("[url \"https://mirror.example.com/\"]\n\tinsteadOf = https://github.com/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := runGitConfigApply(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := gitInsteadOf(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "https://mirror.example.com/ https://github.com/\n" +
+		"https://gerrit-beijing.com/ https://gerrit.example.com/\n" +
+		"ssh://ci-bot@10.67.16.29:29418/ ssh://gerrit.example.com:29418/\n"
+
+	if got := joinEntries(entries); got != want {
+		t.Errorf("applied entries =\n%s\nwant\n%s", got, want)
+	}
+
+	if err := runGitConfigRevert(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err = gitInsteadOf(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := joinEntries(entries), "https://mirror.example.com/ https://github.com/\n"; got != want {
+		t.Errorf("reverted entries =\n%s\nwant\n%s", got, want)
+	}
+
+	buf, err := os.ReadFile(gitFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(buf), "gerrit-beijing") || strings.Contains(string(buf), "10.67.16.29") {
+		t.Errorf("reverted config keeps the sections of the site:\n%s", buf)
+	}
+}
+
+func joinEntries(entries [][]string) string {
+	var b strings.Builder
+
+	for _, entry := range entries {
+		b.WriteString(strings.Join(entry, " ") + "\n")
+	}
+
+	return b.String()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -12,12 +13,28 @@ import (
 	"github.com/repo-scm/proxy/utils"
 )
 
+var (
+	listFormat string
+)
+
+type siteInfo struct {
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	Weight   float32 `json:"weight"`
+	Url      string  `json:"url"`
+	Ssh      string  `json:"ssh"`
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all sites",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 		config := GetConfig()
+		if err := utils.ValidFormat(listFormat); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
 		if err := runList(ctx, config); err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
@@ -28,27 +45,56 @@ var listCmd = &cobra.Command{
 // nolint:gochecknoinits
 func init() {
 	rootCmd.AddCommand(listCmd)
+
+	listCmd.PersistentFlags().StringVarP(&listFormat, "format", "f", utils.FormatTable, "output format (table|json|yaml|csv|env|template)")
 }
 
 func runList(ctx context.Context, cfg *config.Config) error {
-	if err := listTable(ctx, cfg.Gerrits); err != nil {
+	if err := listSites(ctx, cfg.Gerrits); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func listTable(ctx context.Context, sites map[string]config.Gerrit) error {
-	data := [][]string{
-		{"NAME", "LOCATION", "WEIGHT", "HTTP", "SSH"},
+func listSites(ctx context.Context, sites map[string]config.Gerrit) error {
+	names := make([]string, 0, len(sites))
+	for key := range sites {
+		names = append(names, key)
+	}
+
+	sort.Strings(names)
+
+	items := make([]siteInfo, 0, len(names))
+	data := &utils.Format{
+		Header: []string{"NAME", "LOCATION", "WEIGHT", "HTTP", "SSH"},
 	}
 
-	for key, val := range sites {
-		data = append(data, []string{key, val.Location, fmt.Sprintf("%.1f", val.Weight), val.Http.Url, fmt.Sprintf("ssh://%s:%d", val.Ssh.Host, val.Ssh.Port)})
+	for _, key := range names {
+		val := sites[key]
+		item := siteInfo{
+			Name:     key,
+			Location: val.Location,
+			Weight:   val.Weight,
+			Url:      utils.RedactUrl(val.Http.Url),
+			Ssh:      fmt.Sprintf("ssh://%s:%d", val.Ssh.Host, val.Ssh.Port),
+		}
+		items = append(items, item)
+		data.Rows = append(data.Rows, []string{item.Name, item.Location, fmt.Sprintf("%.1f", item.Weight), item.Url, item.Ssh})
+		prefix := envName(key)
+		data.Env = append(data.Env,
+			[]string{prefix + "_LOCATION", item.Location},
+			[]string{prefix + "_WEIGHT", fmt.Sprintf("%.1f", item.Weight)},
+			[]string{prefix + "_URL", item.Url},
+			[]string{prefix + "_HOST", val.Ssh.Host},
+			[]string{prefix + "_PORT", fmt.Sprintf("%d", val.Ssh.Port)},
+		)
 	}
 
-	if err := utils.WriteTable(ctx, data); err != nil {
-		return errors.Wrap(err, "failed to write table\n")
+	data.Data = items
+
+	if err := utils.WriteFormat(ctx, os.Stdout, listFormat, data); err != nil {
+		return errors.Wrap(err, "failed to write sites\n")
 	}
 
 	return nil
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/manifest"
+)
+
+var (
+	manifestDryRun bool
+	manifestGroup  string
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Rewrite repo manifests to available site",
+}
+
+var manifestRewriteCmd = &cobra.Command{
+	Use:   "rewrite <manifest.xml>...",
+	Short: "Rewrite remote fetch and review urls",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := runManifestRewrite(ctx, config, args); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+
+	manifestCmd.AddCommand(manifestRewriteCmd)
+
+	manifestRewriteCmd.PersistentFlags().BoolVarP(&manifestDryRun, "dry-run", "n", false, "print diff without editing manifests")
+	manifestRewriteCmd.PersistentFlags().StringVarP(&manifestGroup, "group", "g", "", "select among the sites of the cluster for all remotes")
+}
+
+// runManifestRewrite rewrites each remote to the best site among the sites of its cluster, or
+// among all sites if its site belongs to no cluster, unless a cluster is given for all remotes.
+func runManifestRewrite(ctx context.Context, cfg *config.Config, names []string) error {
+	picked := map[string]string{}
+
+	pick := func(site string) (string, error) {
+		group := manifestGroup
+		if group == "" {
+			group = siteCluster(cfg, site)
+		}
+		if name, found := picked[group]; found {
+			return name, nil
+		}
+		best, err := selectSite(ctx, cfg, "", group)
+		if err != nil {
+			return "", err
+		}
+		picked[group] = best.Name
+		return best.Name, nil
+	}
+
+	for _, name := range names {
+		if err := rewriteManifest(name, cfg, pick); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// siteCluster returns the first cluster of the site in name order, empty if it has none.
+func siteCluster(cfg *config.Config, site string) string {
+	var clusters []string
+
+	for group, cluster := range cfg.Clusters {
+		if slices.Contains(cluster.Sites, site) {
+			clusters = append(clusters, group)
+		}
+	}
+
+	sort.Strings(clusters)
+
+	if len(clusters) == 0 {
+		return ""
+	}
+
+	return clusters[0]
+}
+
+func rewriteManifest(name string, cfg *config.Config, pick func(string) (string, error)) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	buf, changes, err := manifest.Rewrite(data, cfg, pick)
+	if err != nil {
+		return errors.Wrapf(err, "failed to rewrite %s\n", name)
+	}
+
+	if manifestDryRun {
+		fmt.Print(manifest.Diff(name, data, buf))
+		return nil
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := os.WriteFile(name, buf, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s: remote %s %s %s -> %s\n", name, change.Remote, change.Attribute, change.Old, change.New)
+	}
+
+	return nil
+}
@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
+	"github.com/repo-scm/proxy/client"
 	"github.com/repo-scm/proxy/config"
 	"github.com/repo-scm/proxy/monitor"
 	"github.com/repo-scm/proxy/utils"
@@ -15,7 +21,13 @@ import (
 
 var (
 	outputFile   string
+	queryFormat  string
+	queryServer  string
 	siteName     string
+	topSites     int
+	degraded     bool
+	session      string
+	group        string
 	verboseQuery bool
 )
 
@@ -24,7 +36,8 @@ var queryCmd = &cobra.Command{
 	Short: "Query available site",
 	Run: func(cmd *cobra.Command, args []string) {
 		var _path string
-		ctx := context.Background()
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
 		config := GetConfig()
 		if outputFile != "" {
 			_path = utils.ExpandTilde(outputFile)
@@ -33,6 +46,19 @@ var queryCmd = &cobra.Command{
 				os.Exit(1)
 			}
 		}
+		if siteName != "" && topSites > 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "--site and --top are mutually exclusive")
+			os.Exit(1)
+		}
+		if verboseQuery && queryFormat == "" {
+			queryFormat = utils.FormatJson
+		}
+		if queryFormat != "" {
+			if err := utils.ValidFormat(queryFormat); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		}
 		if err := runQuery(ctx, config, _path); err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
@@ -45,44 +71,160 @@ func init() {
 	rootCmd.AddCommand(queryCmd)
 
 	queryCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "output file")
+	queryCmd.PersistentFlags().StringVarP(&queryFormat, "format", "f", "", "output format (table|json|yaml|csv|env|template, default host only)")
+	queryCmd.PersistentFlags().StringVar(&queryServer, "server", "", "proxy server url (default server.url in config)")
 	queryCmd.PersistentFlags().StringVarP(&siteName, "site", "s", "", "site name")
-	queryCmd.PersistentFlags().BoolVarP(&verboseQuery, "verbose", "v", false, "verbose mode")
+	queryCmd.PersistentFlags().IntVarP(&topSites, "top", "n", 0, "number of ranked sites with fallbacks")
+	queryCmd.PersistentFlags().BoolVar(&degraded, "allow-degraded", false, "return the least bad site if no site is healthy")
+	queryCmd.PersistentFlags().StringVar(&session, "session", "", "session key to keep the same site across queries")
+	queryCmd.PersistentFlags().StringVarP(&group, "group", "g", "", "select among the sites of the cluster")
+	queryCmd.PersistentFlags().BoolVarP(&verboseQuery, "verbose", "v", false, "verbose mode (same as --format json)")
 }
 
-func runQuery(_ context.Context, cfg *config.Config, _path string) error {
-	var buf string
+func runQuery(ctx context.Context, cfg *config.Config, _path string) error {
+	var buf bytes.Buffer
 	var err error
-	var site *monitor.SiteStatus
-
-	m := monitor.NewMonitor(cfg)
+	var sites []*monitor.SiteStatus
 
 	if siteName != "" {
-		if site = m.GetSiteStatus(siteName); site == nil {
+		site := monitor.NewMonitor(cfg).GetSiteStatus(ctx, siteName)
+		if site == nil {
 			return fmt.Errorf("site %s not found", siteName)
 		}
+		sites = []*monitor.SiteStatus{site}
 	} else {
-		if site, err = m.GetAvailableSite(); err != nil {
+		opts := monitor.SelectOptions{
+			AllowDegraded: degraded,
+			Session:       session,
+			Group:         group,
+		}
+		if sites, err = selectSites(ctx, cfg, queryServer, topSites, opts); err != nil {
 			return err
 		}
 	}
 
-	if verboseQuery {
-		s, err := json.Marshal(site)
-		if err != nil {
+	if len(sites) == 1 && sites[0].Degraded {
+		slog.Warn("no healthy site, using degraded site", "site", sites[0].Name, "error", sites[0].Error)
+	}
+
+	if queryFormat != "" {
+		data := statusFormat(sites)
+		if topSites > 0 {
+			data.Data = sites
+		}
+		if err := utils.WriteFormat(ctx, &buf, queryFormat, data); err != nil {
 			return err
 		}
-		buf = string(s)
 	} else {
-		buf = site.Host
+		for _, site := range sites {
+			buf.WriteString(site.Host + "\n")
+		}
 	}
 
 	if _path != "" {
-		if err := os.WriteFile(_path, []byte(buf), utils.PermFile); err != nil {
+		output := buf.Bytes()
+		if queryFormat == "" {
+			// Hosts are written to the file without trailing newline
+			output = bytes.TrimSuffix(output, []byte("\n"))
+		}
+		if err := os.WriteFile(_path, output, utils.PermFile); err != nil {
 			return err
 		}
 	} else {
-		fmt.Println(buf)
+		fmt.Print(buf.String())
 	}
 
 	return nil
 }
+
+func selectSite(ctx context.Context, cfg *config.Config, server, group string) (*monitor.SiteStatus, error) {
+	sites, err := selectSites(ctx, cfg, server, 0, monitor.SelectOptions{Group: group})
+	if err != nil {
+		return nil, err
+	}
+
+	return sites[0], nil
+}
+
+// selectSites returns the available site, or the top ranked sites if top is positive. Answers
+// of the server are cached, local probing is the fallback if the server is unreachable.
+func selectSites(ctx context.Context, cfg *config.Config, server string, top int, opts monitor.SelectOptions) ([]*monitor.SiteStatus, error) {
+	var sites []*monitor.SiteStatus
+	var err error
+
+	if server == "" {
+		server = cfg.Server.Url
+	}
+
+	if server != "" {
+		key := fmt.Sprintf("select@%s?top=%d&degraded=%t&session=%s&group=%s&config=%s",
+			server, top, opts.AllowDegraded, opts.Session, opts.Group, cfg.Hash)
+
+		if sites = loadCache(cfg.Server.Cache, key, cfg.Server.Ttl); sites != nil {
+			slog.Debug("using cached site", "site", sites[0].Name)
+			return sites, nil
+		}
+
+		sites, err = fetchSites(ctx, client.NewClient(server), top, opts)
+		if err == nil {
+			if !degradedSites(sites) {
+				if err := saveCache(cfg.Server.Cache, key, cfg.Server.Ttl, sites); err != nil {
+					slog.Warn("failed to save cache", "error", err)
+				}
+			}
+			return sites, nil
+		}
+
+		// A status is an answer of the server, e.g. 503 when no site is healthy, except for the
+		// gateway errors of a proxy in front of it
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) && statusErr.Code != http.StatusBadGateway && statusErr.Code != http.StatusGatewayTimeout {
+			return nil, err
+		}
+
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		slog.Warn("server unavailable, falling back to local probing", "server", server, "error", err)
+	}
+
+	return probeSites(ctx, monitor.NewMonitor(cfg), top, opts)
+}
+
+func degradedSites(sites []*monitor.SiteStatus) bool {
+	for _, site := range sites {
+		if site.Degraded {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fetchSites(ctx context.Context, c *client.Client, top int, opts monitor.SelectOptions) ([]*monitor.SiteStatus, error) {
+	if top > 0 {
+		return c.GetRankedSites(ctx, top, opts)
+	}
+
+	site, err := c.GetAvailableSite(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*monitor.SiteStatus{site}, nil
+}
+
+func probeSites(ctx context.Context, m *monitor.Monitor, top int, opts monitor.SelectOptions) ([]*monitor.SiteStatus, error) {
+	if top > 0 {
+		opts.Peek = true
+		return m.GetRankedSites(ctx, top, opts)
+	}
+
+	site, err := m.GetAvailableSite(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*monitor.SiteStatus{site}, nil
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/client"
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+)
+
+func TestQueryOutputFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&monitor.SiteStatus{Name: "gerrit-beijing", Host: "10.67.16.29", Healthy: true})
+	}))
+	defer ts.Close()
+
+	queryServer, queryFormat = ts.URL, ""
+	defer func() {
+		queryServer = ""
+	}()
+
+	name := filepath.Join(t.TempDir(), "host")
+
+	if err := runQuery(context.Background(), &config.Config{}, name); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "10.67.16.29" {
+		t.Errorf("output file = %q, want the host without newline", buf)
+	}
+}
+
+// fallbackConfig has a single site which cannot be probed, so that local probing fails with
+// its reason.
+func fallbackConfig(server string) *config.Config {
+	return &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"gerrit-local": {Ssh: config.Ssh{Host: "127.0.0.1", Port: 1}},
+		},
+		Monitor: config.Monitor{Timeout: time.Second, Insecure: true},
+		Server:  config.Server{Url: server},
+	}
+}
+
+func TestSelectSitesNoHealthy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "no healthy site available (gerrit-beijing: timeout)",
+			"reasons": map[string]string{"gerrit-beijing": "timeout"},
+		})
+	}))
+	defer ts.Close()
+
+	_, err := selectSites(context.Background(), fallbackConfig(ts.URL), "", 0, monitor.SelectOptions{})
+
+	// The answer of the server is returned without probing gerrit-local
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want 503 of the server", err)
+	}
+
+	if len(statusErr.Reasons) != 1 || statusErr.Reasons["gerrit-beijing"] != "timeout" {
+		t.Errorf("reasons = %v, want the reason of gerrit-beijing", statusErr.Reasons)
+	}
+}
+
+func TestSelectSitesFallback(t *testing.T) {
+	for _, code := range []int{0, http.StatusBadGateway} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		if code == 0 {
+			ts.Close()
+		}
+
+		_, err := selectSites(context.Background(), fallbackConfig(ts.URL), "", 0, monitor.SelectOptions{})
+
+		var noHealthyErr *monitor.NoHealthySiteError
+		if !errors.As(err, &noHealthyErr) || noHealthyErr.Reasons["gerrit-local"] == "" {
+			t.Errorf("status %d: error = %v, want local probing of gerrit-local", code, err)
+		}
+
+		ts.Close()
+	}
+}
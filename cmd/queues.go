@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/client"
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/utils"
+)
+
+var (
+	queuesFormat string
+	queuesServer string
+	queuesSite   string
+)
+
+var queuesCmd = &cobra.Command{
+	Use:   "queues",
+	Short: "Show queued tasks of site",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := utils.ValidFormat(queuesFormat); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err := runQueues(ctx, config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(queuesCmd)
+
+	queuesCmd.PersistentFlags().StringVarP(&queuesFormat, "format", "f", utils.FormatTable, "output format (table|json|yaml|csv|env|template)")
+	queuesCmd.PersistentFlags().StringVar(&queuesServer, "server", "", "proxy server url (default probe locally)")
+	queuesCmd.PersistentFlags().StringVarP(&queuesSite, "site", "s", "", "site name")
+
+	_ = queuesCmd.MarkPersistentFlagRequired("site")
+}
+
+func runQueues(ctx context.Context, cfg *config.Config) error {
+	var queue *monitor.Queue
+	var err error
+
+	if queuesServer != "" {
+		queue, err = client.NewClient(queuesServer).GetSiteQueues(ctx, queuesSite)
+	} else {
+		if _, found := cfg.Gerrits[queuesSite]; !found {
+			return errors.Errorf("site %s not found\n", queuesSite)
+		}
+		queue, err = monitor.NewMonitor(cfg).GetSiteQueues(ctx, queuesSite)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to get queues\n")
+	}
+
+	data := &utils.Format{
+		Header: []string{"ID", "STATE", "START", "QUEUE", "PROJECT", "COMMAND"},
+		Env: [][]string{
+			{"QUEUE_SIZE", fmt.Sprintf("%d", queue.Size)},
+			{"QUEUE_RUNNING", fmt.Sprintf("%d", queue.Running)},
+			{"QUEUE_WAITING", fmt.Sprintf("%d", queue.Waiting)},
+			{"QUEUE_REPLICATION", fmt.Sprintf("%d", queue.Replication)},
+		},
+		Data: queue,
+	}
+
+	for _, task := range queue.Tasks {
+		data.Rows = append(data.Rows, []string{task.Id, task.State, task.StartTime, task.Queue, task.Project, task.Command})
+	}
+
+	if err := utils.WriteFormat(ctx, os.Stdout, queuesFormat, data); err != nil {
+		return errors.Wrap(err, "failed to write queues\n")
+	}
+
+	if queuesFormat == utils.FormatTable {
+		fmt.Printf("\n%d tasks, %d running, %d waiting, %d replication\n", queue.Size, queue.Running, queue.Waiting, queue.Replication)
+	}
+
+	return nil
+}
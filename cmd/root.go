@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -15,8 +16,12 @@ var (
 )
 
 var (
-	cfgFile string
-	cfgData *config.Config
+	cfgFile   string
+	cfgDir    string
+	cfgSets   []string
+	cfgData   *config.Config
+	logLevel  string
+	logFormat string
 )
 
 var rootCmd = &cobra.Command{
@@ -34,17 +39,54 @@ func Execute() {
 
 // nolint:gochecknoinits
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initLogger, initConfig)
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default $HOME/.repo-scm/proxy.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgDir, "config-dir", "", "directory of config fragments (default $PROXY_CONFIG_DIR or proxy.d next to config file)")
+	rootCmd.PersistentFlags().StringArrayVar(&cfgSets, "set", nil, "override a setting by dotted path, e.g. monitor.interval=30s")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level (debug|info|warn|error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format (json|text)")
 
 	rootCmd.Root().CompletionOptions.DisableDefaultCmd = true
 }
 
+func initLogger() {
+	var handler slog.Handler
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid log level:", logLevel)
+		os.Exit(1)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: level,
+		// Log errors as message only, without stack traces of pkg/errors
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if err, ok := attr.Value.Any().(error); ok {
+				return slog.String(attr.Key, err.Error())
+			}
+			return attr
+		},
+	}
+
+	switch logFormat {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, "invalid log format:", logFormat)
+		os.Exit(1)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
 func initConfig() {
 	var err error
 
-	if cfgData, err = config.LoadConfig(cfgFile); err != nil {
+	if cfgData, err = config.LoadConfig(cfgFile, cfgDir, cfgSets); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
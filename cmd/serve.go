@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,12 +13,15 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/notifier"
 	"github.com/repo-scm/proxy/server"
 )
 
 var (
-	serveAddress string
-	testMode     bool
+	serveAddress  string
+	serveScenario string
+	testMode      bool
 )
 
 var serveCmd = &cobra.Command{
@@ -26,6 +30,9 @@ var serveCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 		config := GetConfig()
+		if !cmd.Flags().Changed("address") && config.Server.Address != "" {
+			serveAddress = config.Server.Address
+		}
 		if err := runServe(ctx, config); err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
@@ -37,20 +44,38 @@ var serveCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(serveCmd)
 
-	serveCmd.PersistentFlags().StringVarP(&serveAddress, "address", "a", ":9090", "serve address")
-	serveCmd.PersistentFlags().BoolVarP(&testMode, "test", "t", false, "test mode")
+	serveCmd.PersistentFlags().StringVarP(&serveAddress, "address", "a", ":9090", "serve address (default server.address in config or :9090)")
+	serveCmd.PersistentFlags().BoolVarP(&testMode, "test", "t", false, "simulation mode with built-in scenario")
+	serveCmd.PersistentFlags().StringVar(&serveScenario, "scenario", "", "simulation mode with scenario file")
 }
 
 func runServe(ctx context.Context, cfg *config.Config) error {
 	var srv *server.Server
 
-	if testMode {
-		srv = server.NewTestServer(cfg)
-		fmt.Println("Running in test mode with mock data")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if testMode || serveScenario != "" {
+		scenario, err := monitor.LoadScenario(serveScenario)
+		if err != nil {
+			return err
+		}
+		scenario.Apply(cfg)
+		srv = server.NewSimulationServer(cfg, scenario)
+		slog.Info("running in simulation mode", "scenario", serveScenario, "sites", len(scenario.Sites))
 	} else {
 		srv = server.NewServer(cfg)
 	}
 
+	srv.SetVersion(BuildTime, CommitID)
+
+	var handler func(context.Context, []*monitor.SiteStatus)
+	if len(cfg.Notify.Receivers) > 0 {
+		handler = notifier.NewNotifier(cfg).Check
+	}
+
+	go srv.Monitor().Run(ctx, handler)
+
 	httpServer := &http.Server{
 		Addr:    serveAddress,
 		Handler: srv.Handler(),
@@ -63,8 +88,7 @@ func runServe(ctx context.Context, cfg *config.Config) error {
 
 	go func() {
 		addr := parseAddress(serveAddress)
-		fmt.Printf("Starting server on %s\n", addr)
-		fmt.Printf("Web UI at %s/ui\n", addr)
+		slog.Info("starting server", "address", addr, "ui", addr+"/ui")
 		if err := httpServer.ListenAndServe(); err != nil {
 			serverErr <- err
 		}
@@ -75,11 +99,14 @@ func runServe(ctx context.Context, cfg *config.Config) error {
 	case <-quit:
 	case err := <-serverErr:
 		if !errors.Is(err, http.ErrServerClosed) {
-			fmt.Printf("Server error: %v\n", err)
+			slog.Error("server error", "error", err)
 		}
 	}
 
-	_ = httpServer.Shutdown(ctx)
+	slog.Info("stopping server")
+
+	cancel()
+	_ = httpServer.Shutdown(context.Background())
 
 	return nil
 }
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/repo-scm/proxy/client"
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	clearScreen = "\033[H\033[2J"
+)
+
+var (
+	statusServer   string
+	statusWatch    bool
+	statusInterval time.Duration
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show health of all sites",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		config := GetConfig()
+		if err := runStatus(ctx, config); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.PersistentFlags().StringVar(&statusServer, "server", "", "proxy server url (default probe locally)")
+	statusCmd.PersistentFlags().BoolVarP(&statusWatch, "watch", "w", false, "watch mode")
+	statusCmd.PersistentFlags().DurationVarP(&statusInterval, "interval", "i", 5*time.Second, "watch interval")
+}
+
+func runStatus(ctx context.Context, cfg *config.Config) error {
+	var fetch func(context.Context) ([]*monitor.SiteStatus, error)
+
+	if statusServer != "" {
+		fetch = client.NewClient(statusServer).GetAllSitesStatus
+	} else {
+		m := monitor.NewMonitor(cfg)
+		fetch = func(ctx context.Context) ([]*monitor.SiteStatus, error) {
+			return m.GetAllSitesStatus(ctx), nil
+		}
+	}
+
+	if !statusWatch {
+		sites, err := fetch(ctx)
+		if err != nil {
+			return err
+		}
+		return statusTable(ctx, sites)
+	}
+
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	for {
+		sites, err := fetch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		fmt.Print(clearScreen)
+		fmt.Printf("Every %s: proxy status\t%s\n\n", statusInterval, time.Now().Format(time.DateTime))
+
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		} else if err := statusTable(ctx, sites); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func statusTable(ctx context.Context, sites []*monitor.SiteStatus) error {
+	data := [][]string{
+		{"NAME", "LOCATION", "HEALTH", "LATENCY", "CONNECTIONS", "QUEUE", "SCORE", "LAST CHECK", "ERROR"},
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Name < sites[j].Name
+	})
+
+	for _, site := range sites {
+		health := "healthy"
+		latency := fmt.Sprintf("%dms", site.ResponseTime)
+		if !site.Healthy {
+			health = "unhealthy"
+			latency = "-"
+		}
+		data = append(data, []string{
+			site.Name,
+			site.Location,
+			health,
+			latency,
+			fmt.Sprintf("%d", site.Connections),
+			fmt.Sprintf("%d", site.QueueSize),
+			fmt.Sprintf("%d", site.Score),
+			site.LastCheck.Format(time.DateTime),
+			site.Error,
+		})
+	}
+
+	if err := utils.WriteTable(ctx, data); err != nil {
+		return errors.Wrap(err, "failed to write table\n")
+	}
+
+	return nil
+}
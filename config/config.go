@@ -1,9 +1,14 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"os"
 	"path"
+	"reflect"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
@@ -12,11 +17,28 @@ import (
 	"github.com/repo-scm/proxy/utils"
 )
 
+const (
+	StrategyBest     = "best"
+	StrategyWeighted = "weighted"
+	StrategyP2c      = "p2c"
+)
+
 //go:embed proxy.yaml
 var configData string
 
 type Config struct {
-	Gerrits map[string]Gerrit `yaml:"gerrits"`
+	Gerrits  map[string]Gerrit  `yaml:"gerrits"`
+	Clusters map[string]Cluster `yaml:"clusters"`
+	Monitor  Monitor            `yaml:"monitor"`
+	Notify   Notify             `yaml:"notify"`
+	Server   Server             `yaml:"server"`
+	Git      Git                `yaml:"git"`
+	Session  Session            `yaml:"session"`
+	Select   Select             `yaml:"select"`
+	Path     string             `yaml:"-"`
+	Hash     string             `yaml:"-"`
+	Files    []string           `yaml:"-"`
+	settings *settings
 }
 
 type Gerrit struct {
@@ -24,20 +46,122 @@ type Gerrit struct {
 	Weight   float32 `yaml:"weight"`
 	Http     Http    `yaml:"http"`
 	Ssh      Ssh     `yaml:"ssh"`
+	Limits   Limits  `yaml:"limits"`
+}
+
+type Limits struct {
+	Connections Limit `yaml:"connections"`
+	Queue       Limit `yaml:"queue"`
+}
+
+type Limit struct {
+	Soft int `yaml:"soft"`
+	Hard int `yaml:"hard"`
+}
+
+type Cluster struct {
+	Sites    []string `yaml:"sites"`
+	Strategy string   `yaml:"strategy"`
 }
 
 type Http struct {
-	Url string `yaml:"url"`
+	Url      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
 }
 
 type Ssh struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
-	User string `yaml:"user"`
-	Key  string `yaml:"key"`
+	Host       string  `yaml:"host"`
+	Port       int     `yaml:"port"`
+	User       string  `yaml:"user"`
+	Key        KeyFile `yaml:"key"`
+	Passphrase Secret  `yaml:"passphrase"`
+	Agent      bool    `yaml:"agent"`
+}
+
+type Monitor struct {
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Concurrency int           `yaml:"concurrency"`
+	KnownHosts  string        `yaml:"knownHosts"`
+	Insecure    bool          `yaml:"insecure"`
+	Idle        time.Duration `yaml:"idle"`
+	Score       Score         `yaml:"score"`
+	Throughput  Throughput    `yaml:"throughput"`
+	Smoothing   Smoothing     `yaml:"smoothing"`
+}
+
+type Smoothing struct {
+	Alpha  float64       `yaml:"alpha"`
+	Margin float64       `yaml:"margin"`
+	Hold   time.Duration `yaml:"hold"`
+}
+
+type Throughput struct {
+	Repo     string        `yaml:"repo"`
+	Protocol string        `yaml:"protocol"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Weight   float64       `yaml:"weight"`
+}
+
+type Score struct {
+	Running     float64 `yaml:"running"`
+	Waiting     float64 `yaml:"waiting"`
+	Replication float64 `yaml:"replication"`
+	Active      bool    `yaml:"active"`
+}
+
+type Session struct {
+	Ttl  time.Duration `yaml:"ttl"`
+	File string        `yaml:"file"`
+}
+
+type Select struct {
+	Strategy string        `yaml:"strategy"`
+	Inflight time.Duration `yaml:"inflight"`
+}
+
+type Git struct {
+	Http string `yaml:"http"`
+	Ssh  string `yaml:"ssh"`
+}
+
+type Server struct {
+	Address string        `yaml:"address"`
+	Url     string        `yaml:"url"`
+	Cache   string        `yaml:"cache"`
+	Ttl     time.Duration `yaml:"ttl"`
 }
 
-func LoadConfig(name string) (*Config, error) {
+type Notify struct {
+	Renotify  time.Duration `yaml:"renotify"`
+	Queue     int           `yaml:"queue"`
+	Score     int           `yaml:"score"`
+	Template  string        `yaml:"template"`
+	Receivers []Receiver    `yaml:"receivers"`
+}
+
+type Receiver struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Url      Secret `yaml:"url"`
+	Template string `yaml:"template"`
+	Smtp     Smtp   `yaml:"smtp"`
+}
+
+type Smtp struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	User     string   `yaml:"user"`
+	Password Secret   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// LoadConfig loads the config file, then merges the yaml fragments of the config directory in
+// name order, the PROXY_* environment variables and the dotted path assignments of sets.
+func LoadConfig(name, dir string, sets []string) (*Config, error) {
 	var config Config
 
 	home, err := os.UserHomeDir()
@@ -66,18 +190,149 @@ func LoadConfig(name string) (*Config, error) {
 		}
 	}
 
-	buf, err := os.ReadFile(viper.ConfigFileUsed())
+	s := newSettings()
+	files := []string{viper.ConfigFileUsed()}
+
+	if dir == "" {
+		dir = os.Getenv(EnvConfigDir)
+	}
+
+	if dir == "" {
+		dir = path.Join(path.Dir(viper.ConfigFileUsed()), FragmentDir)
+	}
+
+	names, err := fragments(utils.ExpandTilde(dir))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list config directory\n")
+	}
+
+	files = append(files, names...)
+
+	for _, name := range files {
+		if err := s.mergeFile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mergeEnv(os.Environ())
+
+	if err := s.mergeSets(sets); err != nil {
+		return nil, err
+	}
+
+	buf, err := yaml.Marshal(s.tree)
 	if err != nil {
 		return nil, err
 	}
 
 	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse config\n")
+	}
+
+	if err := config.validate(); err != nil {
 		return nil, err
 	}
 
+	sum := sha256.Sum256(buf)
+
+	config.Path = viper.ConfigFileUsed()
+	config.Hash = hex.EncodeToString(sum[:])
+	config.Files = files
+	config.settings = s
+
 	return &config, nil
 }
 
+// validate rejects the settings whose mistakes would otherwise only show at selection.
+func (c *Config) validate() error {
+	if !validStrategy(c.Select.Strategy) {
+		return errors.Errorf("invalid select.strategy %s, want best, weighted or p2c\n", c.Select.Strategy)
+	}
+
+	for name, cluster := range c.Clusters {
+		if !validStrategy(cluster.Strategy) {
+			return errors.Errorf("invalid strategy %s of cluster %s, want best, weighted or p2c\n", cluster.Strategy, name)
+		}
+		if len(cluster.Sites) == 0 {
+			return errors.Errorf("cluster %s has no sites\n", name)
+		}
+		for _, site := range cluster.Sites {
+			if _, found := c.Gerrits[site]; !found {
+				return errors.Errorf("unknown site %s in cluster %s\n", site, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validStrategy(strategy string) bool {
+	switch strategy {
+	case "", StrategyBest, StrategyWeighted, StrategyP2c:
+		return true
+	default:
+		return false
+	}
+}
+
+// Show returns the settings of the config file with the literal secrets redacted, keeping its
+// comments while its layout is normalized.
+func (c *Config) Show() ([]byte, error) {
+	buf, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return nil, err
+	}
+
+	redactNode(reflect.TypeOf(Config{}), &node)
+
+	return encodeNode(&node)
+}
+
+// Effective returns the merged settings with the literal secrets redacted, commented with the
+// source of each setting.
+func (c *Config) Effective() ([]byte, error) {
+	if c.settings == nil {
+		return nil, errors.New("config not loaded\n")
+	}
+
+	return encode(redact(reflect.TypeOf(Config{}), c.settings.tree), c.settings)
+}
+
+func encode(tree interface{}, s *settings) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(tree); err != nil {
+		return nil, err
+	}
+
+	if s != nil {
+		s.annotate(&node, nil)
+	}
+
+	return encodeNode(&node)
+}
+
+func encodeNode(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 func createConfig(name string) error {
 	if err := os.MkdirAll(path.Dir(name), utils.PermDir); err != nil {
 		return err
@@ -1 +1,200 @@
 package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "proxy.yaml")
+
+	writeFile(t, name, `
+gerrits:
+  "gerrit-beijing":
+    location: "Beijing"
+    ssh:
+      host: "10.0.0.1"
+      port: 29418
+monitor:
+  interval: 1m
+  timeout: 10s
+`)
+	writeFile(t, filepath.Join(dir, FragmentDir, "20-monitor.yaml"), `
+monitor:
+  timeout: 20s
+`)
+	writeFile(t, filepath.Join(dir, FragmentDir, "10-sites.yaml"), `
+gerrits:
+  "gerrit-shanghai":
+    location: "Shanghai"
+monitor:
+  timeout: 5s
+`)
+
+	t.Setenv("PROXY_MONITOR_INTERVAL", "30s")
+	t.Setenv("PROXY_MONITOR_KNOWNHOSTS", "/etc/ssh/known_hosts")
+	t.Setenv("PROXY_GERRITS_GERRIT_BEIJING_SSH_HOST", "10.0.0.2")
+	t.Setenv("PROXY_CLUSTERS_ANDROID_SITES", "[gerrit-beijing, gerrit-shanghai]")
+	t.Setenv("PROXY_SERVER_URL", "123")
+
+	cfg, err := LoadConfig(name, "", []string{"monitor.interval=45s", "gerrits.gerrit-shanghai.weight=0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Monitor.Interval != 45*time.Second || cfg.Monitor.Timeout != 20*time.Second {
+		t.Errorf("monitor = %s, %s, want 45s from --set and 20s from the last fragment", cfg.Monitor.Interval, cfg.Monitor.Timeout)
+	}
+
+	if cfg.Monitor.KnownHosts != "/etc/ssh/known_hosts" || cfg.Server.Url != "123" {
+		t.Errorf("strings = %q, %q, want values of the environment", cfg.Monitor.KnownHosts, cfg.Server.Url)
+	}
+
+	if beijing := cfg.Gerrits["gerrit-beijing"]; beijing.Ssh.Host != "10.0.0.2" || beijing.Ssh.Port != 29418 || beijing.Location != "Beijing" {
+		t.Errorf("gerrit-beijing = %+v, want host overridden only", beijing)
+	}
+
+	if shanghai := cfg.Gerrits["gerrit-shanghai"]; shanghai.Location != "Shanghai" || shanghai.Weight != 0.5 {
+		t.Errorf("gerrit-shanghai = %+v, want merged from fragment and --set", shanghai)
+	}
+
+	if got := strings.Join(cfg.Clusters["android"].Sites, ","); got != "gerrit-beijing,gerrit-shanghai" {
+		t.Errorf("android sites = %s", got)
+	}
+
+	buf, err := cfg.Effective()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"interval: 45s # flag --set monitor.interval",
+		"timeout: 20s # " + filepath.Join(dir, FragmentDir, "20-monitor.yaml"),
+		"host: 10.0.0.2 # env PROXY_GERRITS_GERRIT_BEIJING_SSH_HOST",
+		"port: 29418 # " + name,
+	} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("effective config misses %q:\n%s", want, buf)
+		}
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proxy.yaml")
+
+	writeFile(t, name, "monitor:\n  interval: 1m\n")
+
+	for _, set := range []string{"monitor.interval=soon", "monitor.unknown=1", "monitor.interval"} {
+		if _, err := LoadConfig(name, "", []string{set}); err == nil {
+			t.Errorf("--set %s loaded, want error", set)
+		}
+	}
+
+	for _, set := range []string{"select.strategy=fastest", "clusters.android.strategy=p2"} {
+		if _, err := LoadConfig(name, "", []string{set}); err == nil || !strings.Contains(err.Error(), "strategy") {
+			t.Errorf("--set %s = %v, want invalid strategy", set, err)
+		}
+	}
+
+	for set, want := range map[string]string{
+		"clusters.android.sites=[gerrit-unknown]": "unknown site gerrit-unknown in cluster android",
+		"clusters.android.sites=[]":               "cluster android has no sites",
+	} {
+		if _, err := LoadConfig(name, "", []string{set}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("--set %s = %v, want %q", set, err, want)
+		}
+	}
+
+	t.Setenv("PROXY_MONITOR_UNKNOWN", "1")
+
+	if _, err := LoadConfig(name, "", nil); err != nil {
+		t.Errorf("unknown environment variable failed with %v, want ignored", err)
+	}
+}
+
+func TestEffectiveNonScalar(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proxy.yaml")
+
+	writeFile(t, name, `
+gerrits:
+  "gerrit-beijing":
+    location: "beijing"
+notify:
+  receivers:
+    - name: "webhook"
+select:
+  strategy: "best"
+`)
+
+	t.Setenv("PROXY_NOTIFY_RECEIVERS", "[]")
+
+	cfg, err := LoadConfig(name, "", []string{"clusters.android={sites: [gerrit-beijing]}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := cfg.Effective()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The source of lists and mappings is commented on the line before their key
+	for _, want := range []string{
+		"# env PROXY_NOTIFY_RECEIVERS\n  receivers: []\n",
+		"# flag --set clusters.android\n    sites:\n",
+		"strategy: best # " + name,
+	} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("effective config misses %q:\n%s", want, buf)
+		}
+	}
+
+	if strings.Contains(string(buf), "select: #") {
+		t.Errorf("effective config comments the next key:\n%s", buf)
+	}
+}
+
+func TestShowKeepsComments(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proxy.yaml")
+
+	writeFile(t, name, `# sites of the company
+gerrits:
+  "gerrit-beijing":
+    ssh:
+      key: "~/.ssh/id_ed25519" # deploy key
+monitor:
+  interval: 1m # probe often
+`)
+
+	cfg, err := LoadConfig(name, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := cfg.Show()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"# sites of the company", "key: \"~/.ssh/id_ed25519\" # deploy key", "interval: 1m # probe often"} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("config misses %q:\n%s", want, buf)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	Redacted = "******"
+
+	secretEnv  = "env:"
+	secretFile = "file:"
+	secretExec = "exec:"
+)
+
+// Secret is a config value which is either a literal or a reference to its content: env:VAR
+// for an environment variable, file:/path for a file, or exec:command for the output of a
+// helper command run by sh. Literals are redacted when printed, references are not.
+type Secret string
+
+func (s Secret) IsReference() bool {
+	value := string(s)
+
+	return strings.HasPrefix(value, secretEnv) || strings.HasPrefix(value, secretFile) || strings.HasPrefix(value, secretExec)
+}
+
+// Resolve returns the content of the secret, without the trailing newline of files and
+// commands.
+func (s Secret) Resolve(ctx context.Context) (string, error) {
+	value := string(s)
+
+	switch {
+	case strings.HasPrefix(value, secretEnv):
+		name := strings.TrimPrefix(value, secretEnv)
+		content, found := os.LookupEnv(name)
+		if !found {
+			return "", errors.Errorf("secret environment variable %s not set", name)
+		}
+		return content, nil
+	case strings.HasPrefix(value, secretFile):
+		name := strings.TrimPrefix(value, secretFile)
+		buf, err := os.ReadFile(utils.ExpandTilde(name))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read secret file")
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	case strings.HasPrefix(value, secretExec):
+		var stderr bytes.Buffer
+		// The output is the secret, so only stderr is reported on failure
+		cmd := exec.CommandContext(ctx, "sh", "-c", strings.TrimPrefix(value, secretExec))
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to run secret helper %s: %s", cmd.Args[2], strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
+
+func (s Secret) String() string {
+	if s == "" || s.IsReference() {
+		return string(s)
+	}
+
+	return Redacted
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// KeyFile is the path of a private key file, or a secret reference to the content of the key.
+// Paths are no secrets, so it is printed as is.
+type KeyFile string
+
+func (k KeyFile) IsReference() bool {
+	return Secret(k).IsReference()
+}
+
+// Resolve returns the content of the key, read from its file or from its secret reference.
+func (k KeyFile) Resolve(ctx context.Context) (string, error) {
+	if k.IsReference() {
+		return Secret(k).Resolve(ctx)
+	}
+
+	buf, err := os.ReadFile(utils.ExpandTilde(string(k)))
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretResolve(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "password")
+	writeFile(t, name, "from-file\n")
+
+	t.Setenv("PROXY_TEST_SECRET", "from-env")
+
+	for secret, want := range map[Secret]string{
+		"literal":                 "literal",
+		"env:PROXY_TEST_SECRET":   "from-env",
+		Secret("file:" + name):    "from-file",
+		"exec:echo from-exec":     "from-exec",
+		"exec:printf 'a b\\n\\n'": "a b",
+	} {
+		got, err := secret.Resolve(context.Background())
+		if err != nil {
+			t.Errorf("%s: %v", secret, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", secret, got, want)
+		}
+	}
+
+	for _, secret := range []Secret{"env:PROXY_TEST_MISSING", "file:/nonexistent", "exec:echo $((6*7)); exit 1"} {
+		_, err := secret.Resolve(context.Background())
+		if err == nil {
+			t.Errorf("%s resolved, want error", secret)
+			continue
+		}
+		if strings.Contains(err.Error(), "42") {
+			t.Errorf("%s error leaks the output: %v", secret, err)
+		}
+	}
+}
+
+func TestSecretRedaction(t *testing.T) {
+	if got := Secret("hunter2").String(); got != Redacted {
+		t.Errorf("literal = %q, want redacted", got)
+	}
+
+	if got := Secret("env:SMTP_PASSWORD").String(); got != "env:SMTP_PASSWORD" {
+		t.Errorf("reference = %q, want kept", got)
+	}
+
+	buf, err := json.Marshal(Smtp{Password: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(buf), "hunter2") {
+		t.Errorf("json leaks the secret: %s", buf)
+	}
+
+	name := filepath.Join(t.TempDir(), "proxy.yaml")
+	writeFile(t, name, `
+gerrits:
+  "gerrit-beijing":
+    http:
+      password: "hunter2"
+    ssh:
+      key: "~/.ssh/id_rsa"
+      passphrase: "env:SSH_PASSPHRASE"
+notify:
+  receivers:
+    - name: "slack"
+      url: "https://hooks.slack.com/services/secret"
+      smtp:
+        password: 1234
+`)
+
+	cfg, err := LoadConfig(name, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, show := range []func() ([]byte, error){cfg.Show, cfg.Effective} {
+		buf, err := show()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"hunter2", "services/secret", "1234"} {
+			if strings.Contains(string(buf), secret) {
+				t.Errorf("config leaks %s:\n%s", secret, buf)
+			}
+		}
+		// References and key paths hold no secret content
+		for _, want := range []string{"env:SSH_PASSPHRASE", "~/.ssh/id_rsa"} {
+			if !strings.Contains(string(buf), want) {
+				t.Errorf("config misses %s:\n%s", want, buf)
+			}
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvPrefix    = "PROXY_"
+	EnvConfigDir = "PROXY_CONFIG_DIR"
+	FragmentDir  = "proxy.d"
+)
+
+// settings is the yaml tree of the config merged from its sources, with the source of each
+// setting by dotted path.
+type settings struct {
+	tree    map[string]interface{}
+	sources map[string]string
+}
+
+func newSettings() *settings {
+	return &settings{
+		tree:    make(map[string]interface{}),
+		sources: make(map[string]string),
+	}
+}
+
+// fragments returns the yaml files of the directory in name order.
+func fragments(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// mergeFile merges the file into the settings, so that its mappings are merged key by key and
+// its other values replace the previous ones.
+func (s *settings) mergeFile(name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(buf, &tree); err != nil {
+		return errors.Wrapf(err, "failed to parse %s\n", name)
+	}
+
+	for key, val := range tree {
+		s.merge(s.tree, []string{key}, val, name)
+	}
+
+	return nil
+}
+
+func (s *settings) merge(parent map[string]interface{}, path []string, value interface{}, source string) {
+	key := path[len(path)-1]
+
+	if src, ok := value.(map[string]interface{}); ok {
+		if dst, ok := parent[key].(map[string]interface{}); ok {
+			for k, v := range src {
+				s.merge(dst, appendPath(path, k), v, source)
+			}
+			return
+		}
+	}
+
+	parent[key] = value
+
+	s.forget(path)
+	s.record(path, value, source)
+}
+
+// mergeEnv overrides the settings with the PROXY_* environment variables, e.g.
+// PROXY_MONITOR_INTERVAL=30s for monitor.interval.
+func (s *settings) mergeEnv(environ []string) {
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || name == EnvConfigDir {
+			continue
+		}
+
+		names := strings.Split(strings.TrimPrefix(name, EnvPrefix), "_")
+
+		if err := s.override(names, true, value, "env "+name); err != nil {
+			slog.Warn("ignored environment variable", "name", name, "error", err)
+		}
+	}
+}
+
+// mergeSets overrides the settings with dotted path assignments, e.g. monitor.interval=30s.
+func (s *settings) mergeSets(sets []string) error {
+	for _, set := range sets {
+		name, value, found := strings.Cut(set, "=")
+		if !found {
+			return errors.Errorf("invalid setting %s, want path=value\n", set)
+		}
+
+		if err := s.override(strings.Split(name, "."), false, value, "flag --set "+name); err != nil {
+			return errors.Wrapf(err, "invalid setting %s\n", set)
+		}
+	}
+
+	return nil
+}
+
+// override sets the value of the setting named by names, which is parsed as yaml unless the
+// setting is a string.
+func (s *settings) override(names []string, env bool, value, source string) error {
+	path, leaf, ok := resolve(reflect.TypeOf(Config{}), s.tree, names, env)
+	if !ok {
+		return errors.New("unknown setting")
+	}
+
+	var parsed interface{} = value
+
+	if leaf.Kind() != reflect.String {
+		if err := yaml.Unmarshal([]byte(value), reflect.New(leaf).Interface()); err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			return err
+		}
+	}
+
+	parent := s.tree
+	for _, key := range path[:len(path)-1] {
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			parent[key] = child
+		}
+		parent = child
+	}
+
+	parent[path[len(path)-1]] = parsed
+
+	s.forget(path)
+	s.record(path, parsed, source)
+
+	return nil
+}
+
+// resolve returns the yaml path and the type of the setting named by names, with struct fields
+// matched case-insensitively. Map keys match the existing keys first, and span several names
+// of environment variables, since their names may contain the separator.
+func resolve(t reflect.Type, node interface{}, names []string, env bool) ([]string, reflect.Type, bool) {
+	if len(names) == 0 {
+		return nil, t, true
+	}
+
+	tree, _ := node.(map[string]interface{})
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" || !strings.EqualFold(tag, names[0]) {
+				continue
+			}
+			if path, leaf, ok := resolve(field.Type, tree[tag], names[1:], env); ok {
+				return append([]string{tag}, path...), leaf, true
+			}
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, nil, false
+		}
+
+		span := 1
+		if env {
+			span = len(names)
+		}
+
+		// Existing keys first, the longest match wins
+		keys := make([]string, 0, len(tree))
+		for key := range tree {
+			keys = append(keys, key)
+		}
+
+		sort.Slice(keys, func(i, j int) bool {
+			return len(keys[i]) > len(keys[j])
+		})
+
+		for _, key := range keys {
+			for n := 1; n <= span; n++ {
+				if !matchKey(key, names[:n], env) {
+					continue
+				}
+				if path, leaf, ok := resolve(t.Elem(), tree[key], names[n:], env); ok {
+					return append([]string{key}, path...), leaf, true
+				}
+			}
+		}
+
+		for n := 1; n <= span; n++ {
+			key := names[0]
+			if env {
+				key = strings.ToLower(strings.Join(names[:n], "-"))
+			}
+			if path, leaf, ok := resolve(t.Elem(), nil, names[n:], env); ok {
+				return append([]string{key}, path...), leaf, true
+			}
+		}
+	default:
+		return nil, nil, false
+	}
+
+	return nil, nil, false
+}
+
+// matchKey matches a map key against names, with dashes and dots of the key matching the
+// underscores of environment variables.
+func matchKey(key string, names []string, env bool) bool {
+	if !env {
+		return key == strings.Join(names, ".")
+	}
+
+	normalized := strings.NewReplacer("-", "_", ".", "_").Replace(key)
+
+	return strings.EqualFold(normalized, strings.Join(names, "_"))
+}
+
+// forget drops the sources of the setting and of the settings below it.
+func (s *settings) forget(path []string) {
+	name := strings.Join(path, ".")
+
+	for key := range s.sources {
+		if key == name || strings.HasPrefix(key, name+".") {
+			delete(s.sources, key)
+		}
+	}
+}
+
+// record sets the source of the setting and of the settings below it, lists being a single
+// setting.
+func (s *settings) record(path []string, value interface{}, source string) {
+	tree, ok := value.(map[string]interface{})
+	if !ok || len(tree) == 0 {
+		s.sources[strings.Join(path, ".")] = source
+		return
+	}
+
+	for key, val := range tree {
+		s.record(appendPath(path, key), val, source)
+	}
+}
+
+// annotate comments the values of the yaml node with their sources.
+func (s *settings) annotate(node *yaml.Node, path []string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			s.annotate(child, path)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			name := appendPath(path, key.Value)
+			// Line comments of the keys of lists and mappings are printed after their values
+			if source, found := s.sources[strings.Join(name, ".")]; found {
+				if val.Kind == yaml.ScalarNode {
+					val.LineComment = source
+				} else {
+					key.HeadComment = source
+				}
+			}
+			s.annotate(val, name)
+		}
+	}
+}
+
+// redact returns a copy of the tree with the literal secrets hidden and their references kept.
+func redact(t reflect.Type, node interface{}) interface{} {
+	if t == reflect.TypeOf(Secret("")) {
+		if node == nil {
+			return nil
+		}
+		return Secret(fmt.Sprint(node)).String()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		tree, ok := node.(map[string]interface{})
+		if !ok {
+			return node
+		}
+		result := make(map[string]interface{}, len(tree))
+		for key, val := range tree {
+			result[key] = val
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if val, found := tree[tag]; found && tag != "" && tag != "-" {
+				result[tag] = redact(field.Type, val)
+			}
+		}
+		return result
+	case reflect.Map:
+		tree, ok := node.(map[string]interface{})
+		if !ok {
+			return node
+		}
+		result := make(map[string]interface{}, len(tree))
+		for key, val := range tree {
+			result[key] = redact(t.Elem(), val)
+		}
+		return result
+	case reflect.Slice:
+		list, ok := node.([]interface{})
+		if !ok {
+			return node
+		}
+		result := make([]interface{}, len(list))
+		for i, val := range list {
+			result[i] = redact(t.Elem(), val)
+		}
+		return result
+	default:
+		return node
+	}
+}
+
+// redactNode hides the literal secrets of the yaml node in place, keeping its comments.
+func redactNode(t reflect.Type, node *yaml.Node) {
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			redactNode(t, child)
+		}
+		return
+	}
+
+	if t == reflect.TypeOf(Secret("")) {
+		if node.Kind == yaml.ScalarNode && node.Tag != "!!null" {
+			node.Value = Secret(node.Value).String()
+			node.Tag = "!!str"
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			for j := 0; j < t.NumField(); j++ {
+				field := t.Field(j)
+				tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+				if tag != "" && tag != "-" && tag == node.Content[i].Value {
+					redactNode(field.Type, node.Content[i+1])
+				}
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			redactNode(t.Elem(), node.Content[i+1])
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, child := range node.Content {
+			redactNode(t.Elem(), child)
+		}
+	}
+}
+
+func appendPath(path []string, key string) []string {
+	return append(append([]string{}, path...), key)
+}
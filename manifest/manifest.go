@@ -0,0 +1,182 @@
+package manifest
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+var (
+	remotePattern    = regexp.MustCompile(`(?s)<remote\b[^>]*>`)
+	attributePattern = regexp.MustCompile(`\b(name|fetch|review)(\s*=\s*)("[^"]*"|'[^']*')`)
+)
+
+type Change struct {
+	Remote    string `json:"remote"`
+	Attribute string `json:"attribute"`
+	Site      string `json:"site"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+}
+
+// Rewrite replaces fetch and review urls of remotes matching a configured site with
+// the urls of the site returned by pick, leaving the rest of the manifest untouched.
+func Rewrite(data []byte, cfg *config.Config, pick func(string) (string, error)) ([]byte, []Change, error) {
+	var changes []Change
+	var err error
+
+	buf := remotePattern.ReplaceAllFunc(data, func(remote []byte) []byte {
+		if err != nil {
+			return remote
+		}
+
+		var name, site string
+
+		for _, match := range attributePattern.FindAllSubmatch(remote, -1) {
+			value := string(match[3][1 : len(match[3])-1])
+			if string(match[1]) == "name" {
+				name = value
+			} else if site == "" {
+				site = matchSite(cfg, value)
+			}
+		}
+
+		if site == "" {
+			return remote
+		}
+
+		best, e := pick(site)
+		if e != nil {
+			err = e
+			return remote
+		}
+
+		return attributePattern.ReplaceAllFunc(remote, func(attribute []byte) []byte {
+			match := attributePattern.FindSubmatch(attribute)
+			key, quote := string(match[1]), match[3][0]
+			value := string(match[3][1 : len(match[3])-1])
+			if key == "name" {
+				return attribute
+			}
+			newValue, found := rewriteUrl(cfg, value, best)
+			if !found || newValue == value {
+				return attribute
+			}
+			changes = append(changes, Change{
+				Remote:    name,
+				Attribute: key,
+				Site:      best,
+				Old:       value,
+				New:       newValue,
+			})
+			return []byte(fmt.Sprintf("%s%s%c%s%c", key, match[2], quote, newValue, quote))
+		})
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf, changes, nil
+}
+
+func matchSite(cfg *config.Config, value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	names := make([]string, 0, len(cfg.Gerrits))
+	for name := range cfg.Gerrits {
+		names = append(names, name)
+	}
+
+	// Sites sharing a host match the first in name order
+	sort.Strings(names)
+
+	for _, name := range names {
+		site := cfg.Gerrits[name]
+		if siteUrl, err := url.Parse(site.Http.Url); err == nil && siteUrl.Host != "" && strings.EqualFold(siteUrl.Host, u.Host) {
+			return name
+		}
+		if site.Ssh.Host != "" && strings.EqualFold(site.Ssh.Host, u.Hostname()) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func rewriteUrl(cfg *config.Config, value, best string) (string, bool) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	site := cfg.Gerrits[matchSite(cfg, value)]
+	target := cfg.Gerrits[best]
+
+	switch u.Scheme {
+	case "ssh":
+		if target.Ssh.Host == "" {
+			return "", false
+		}
+		u.Host = fmt.Sprintf("%s:%d", target.Ssh.Host, target.Ssh.Port)
+		return u.String(), true
+	default:
+		siteBase := strings.TrimSuffix(site.Http.Url, "/")
+		targetBase := strings.TrimSuffix(target.Http.Url, "/")
+		if siteBase == "" || targetBase == "" {
+			return "", false
+		}
+		if strings.HasPrefix(value, siteBase) {
+			return targetBase + strings.TrimPrefix(value, siteBase), true
+		}
+		targetUrl, err := url.Parse(targetBase)
+		if err != nil {
+			return "", false
+		}
+		u.Scheme = targetUrl.Scheme
+		u.Host = targetUrl.Host
+		return u.String(), true
+	}
+}
+
+// Diff returns a unified diff without context lines, as the rewrite never changes the number of lines.
+func Diff(name string, oldData, newData []byte) string {
+	var buf strings.Builder
+
+	oldLines := strings.Split(string(oldData), "\n")
+	newLines := strings.Split(string(newData), "\n")
+
+	if len(oldLines) != len(newLines) {
+		return ""
+	}
+
+	for i := 0; i < len(oldLines); {
+		if oldLines[i] == newLines[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(oldLines) && oldLines[i] != newLines[i] {
+			i++
+		}
+		if buf.Len() == 0 {
+			buf.WriteString(fmt.Sprintf("--- a/%s\n+++ b/%s\n", name, name))
+		}
+		buf.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", start+1, i-start, start+1, i-start))
+		for _, line := range oldLines[start:i] {
+			buf.WriteString("-" + line + "\n")
+		}
+		for _, line := range newLines[start:i] {
+			buf.WriteString("+" + line + "\n")
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,126 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	file := filepath.Join("testdata", name)
+
+	if *update {
+		if err := os.WriteFile(file, got, 0o644); err != nil { // nolint:gosec
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run go test -update to regenerate)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"beijing": {
+				Http: config.Http{Url: "https://beijing.example.com"},
+				Ssh:  config.Ssh{Host: "beijing.example.com", Port: 29418},
+			},
+			"shanghai": {
+				Http: config.Http{Url: "https://shanghai.example.com/gerrit"},
+				Ssh:  config.Ssh{Host: "shanghai.example.com", Port: 29418},
+			},
+			"shenzhen": {
+				Http: config.Http{Url: "https://shenzhen.example.com/"},
+				Ssh:  config.Ssh{Host: "shenzhen.example.com", Port: 29419},
+			},
+		},
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "default.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each remote is rewritten to the site picked for its own site
+	picks := map[string]string{"beijing": "shenzhen", "shanghai": "beijing"}
+
+	var picked []string
+
+	buf, changes, err := Rewrite(data, testConfig(), func(site string) (string, error) {
+		picked = append(picked, site)
+		return picks[site], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(picked) != 2 || picked[0] != "beijing" || picked[1] != "shanghai" {
+		t.Errorf("picked for %v, want beijing and shanghai", picked)
+	}
+
+	golden(t, "default.golden.xml", buf)
+	golden(t, "default.diff", []byte(Diff("default.xml", data, buf)))
+
+	result, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden(t, "default.changes.json", append(result, '\n'))
+}
+
+func TestRewriteUnmatched(t *testing.T) {
+	data := []byte(`<manifest><remote name="github" fetch="https://github.com/" /></manifest>`)
+
+	buf, changes, err := Rewrite(data, testConfig(), func(string) (string, error) {
+		t.Fatal("picked a site for an unmatched remote")
+		return "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, data) || len(changes) != 0 {
+		t.Errorf("rewrite = %s with %d changes, want unchanged", buf, len(changes))
+	}
+
+	if diff := Diff("default.xml", data, buf); diff != "" {
+		t.Errorf("diff = %q, want empty", diff)
+	}
+}
+
+func TestMatchSite(t *testing.T) {
+	cfg := testConfig()
+
+	// Sites sharing a host match the first in name order
+	cfg.Gerrits["anhui"] = cfg.Gerrits["beijing"]
+
+	for i := 0; i < 10; i++ {
+		if site := matchSite(cfg, "https://beijing.example.com/platform"); site != "anhui" {
+			t.Fatalf("matched %s, want anhui", site)
+		}
+	}
+
+	if site := matchSite(cfg, "ssh://shanghai.example.com:29418/kernel"); site != "shanghai" {
+		t.Errorf("matched %s, want shanghai by ssh host", site)
+	}
+}
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"sort"
+)
+
+type GroupNotFoundError struct {
+	Group string
+}
+
+func (e *GroupNotFoundError) Error() string {
+	return "group " + e.Group + " not found"
+}
+
+// groupSites returns the names of the sites of the group, or of all sites without group.
+func (m *Monitor) groupSites(group string) ([]string, error) {
+	if group == "" {
+		names := make([]string, 0, len(m.sites))
+		for name := range m.sites {
+			names = append(names, name)
+		}
+		return names, nil
+	}
+
+	cluster, found := m.config.Clusters[group]
+	if !found {
+		return nil, &GroupNotFoundError{Group: group}
+	}
+
+	names := make([]string, 0, len(cluster.Sites))
+
+	for _, name := range cluster.Sites {
+		if _, found := m.sites[name]; found {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// strategy returns the selection strategy of the group, which defaults to the global one.
+func (m *Monitor) strategy(group string) string {
+	if strategy := m.config.Clusters[group].Strategy; group != "" && strategy != "" {
+		return strategy
+	}
+
+	return m.config.Select.Strategy
+}
+
+// clusters returns the sorted names of the clusters of a site.
+func (m *Monitor) clusters(name string) []string {
+	var clusters []string
+
+	for group, cluster := range m.config.Clusters {
+		for _, site := range cluster.Sites {
+			if site == name {
+				clusters = append(clusters, group)
+				break
+			}
+		}
+	}
+
+	sort.Strings(clusters)
+
+	return clusters
+}
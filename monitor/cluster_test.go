@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+func TestGetRankedSitesGroup(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt"),
+		"shenzhen": newSite(t, "show-queue-2.16.txt", "show-connections-2.16.txt"),
+	})
+
+	m.config.Clusters = map[string]config.Cluster{
+		"android": {Sites: []string{"shanghai", "shenzhen"}},
+		"kernel":  {Sites: []string{"beijing", "shenzhen", "unknown"}},
+	}
+
+	sites, err := m.GetRankedSites(context.Background(), 0, SelectOptions{Group: "kernel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusters := make(map[string][]string)
+	for _, site := range sites {
+		clusters[site.Name] = site.Clusters
+	}
+
+	want := map[string][]string{
+		"beijing":  {"kernel"},
+		"shenzhen": {"android", "kernel"},
+	}
+
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("kernel sites = %v, want %v", clusters, want)
+	}
+
+	site, err := m.GetAvailableSite(context.Background(), SelectOptions{Group: "android"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "shanghai" {
+		t.Errorf("android site = %s, want shanghai", site.Name)
+	}
+
+	_, err = m.GetAvailableSite(context.Background(), SelectOptions{Group: "chromium"})
+
+	var groupErr *GroupNotFoundError
+	if !errors.As(err, &groupErr) || groupErr.Group != "chromium" {
+		t.Errorf("error = %v, want GroupNotFoundError", err)
+	}
+}
+
+func TestGroupSession(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt"),
+	})
+
+	m.config.Clusters = map[string]config.Cluster{
+		"kernel": {Sites: []string{"beijing"}},
+	}
+
+	site, err := m.GetAvailableSite(context.Background(), SelectOptions{Group: "kernel", Session: "job-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "beijing" {
+		t.Fatalf("kernel site = %s, want beijing", site.Name)
+	}
+
+	// The same session key in another group is a different session
+	if site, err = m.GetAvailableSite(context.Background(), SelectOptions{Session: "job-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "shanghai" {
+		t.Errorf("ungrouped site = %s, want shanghai", site.Name)
+	}
+}
+
+func TestGroupStrategy(t *testing.T) {
+	m := &Monitor{config: &config.Config{
+		Clusters: map[string]config.Cluster{
+			"android": {Strategy: StrategyP2c},
+			"kernel":  {},
+		},
+		Select: config.Select{Strategy: StrategyWeighted},
+	}}
+
+	for group, want := range map[string]string{"android": StrategyP2c, "kernel": StrategyWeighted, "": StrategyWeighted} {
+		if got := m.strategy(group); got != want {
+			t.Errorf("strategy of %q = %s, want %s", group, got, want)
+		}
+	}
+}
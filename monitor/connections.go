@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	idlePattern  = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})$`)
+	countPattern = regexp.MustCompile(`^(\d+)\s+connections?\b`)
+)
+
+type Session struct {
+	Id          string `json:"id"`
+	User        string `json:"user"`
+	Host        string `json:"host"`
+	Start       string `json:"start"`
+	Idle        string `json:"idle"`
+	IdleSeconds int64  `json:"idleSeconds"`
+	Active      bool   `json:"active"`
+}
+
+type SessionGroup struct {
+	Name     string `json:"name"`
+	Sessions int    `json:"sessions"`
+	Active   int    `json:"active"`
+}
+
+type Connections struct {
+	Count    int             `json:"connections"`
+	Active   int             `json:"active"`
+	Sessions []*Session      `json:"sessions"`
+	Users    []*SessionGroup `json:"users"`
+	Hosts    []*SessionGroup `json:"hosts"`
+}
+
+// parseConnections parses the output of gerrit show-connections -w. The count is taken from
+// the "N connections" footer if present, otherwise from the number of sessions listed.
+func parseConnections(output string, idle time.Duration) (*Connections, error) {
+	connections := &Connections{Count: -1, Sessions: []*Session{}}
+	hasHeader := false
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "--"):
+			continue
+		case strings.HasPrefix(trimmed, "Session "):
+			hasHeader = true
+			continue
+		}
+
+		if match := countPattern.FindStringSubmatch(trimmed); match != nil {
+			connections.Count, _ = strconv.Atoi(match[1])
+			continue
+		}
+
+		if session := parseSession(trimmed); session != nil {
+			session.Active = idle <= 0 || time.Duration(session.IdleSeconds)*time.Second < idle
+			connections.Sessions = append(connections.Sessions, session)
+		}
+	}
+
+	if connections.Count < 0 {
+		if !hasHeader {
+			return nil, errors.New("failed to parse connections")
+		}
+		connections.Count = len(connections.Sessions)
+	}
+
+	users := map[string]*SessionGroup{}
+	hosts := map[string]*SessionGroup{}
+
+	for _, session := range connections.Sessions {
+		user := groupSession(users, session.User)
+		host := groupSession(hosts, session.Host)
+		if session.Active {
+			connections.Active++
+			user.Active++
+			host.Active++
+		}
+	}
+
+	// Sessions not listed, e.g. cut off by the server, are counted as active
+	connections.Active += max(0, connections.Count-len(connections.Sessions))
+
+	connections.Users = sortGroups(users)
+	connections.Hosts = sortGroups(hosts)
+
+	return connections, nil
+}
+
+func parseSession(line string) *Session {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !taskPattern.MatchString(fields[0]) {
+		return nil
+	}
+
+	session := &Session{Id: fields[0]}
+	i := 1
+
+	// Sessions older than a day start at "MMM-dd HH:mm"
+	if datePattern.MatchString(fields[i]) && minutePattern.MatchString(fields[i+1]) {
+		session.Start = fields[i] + " " + fields[i+1]
+		i += 2
+	} else {
+		session.Start = fields[i]
+		i++
+	}
+
+	if i >= len(fields) {
+		return nil
+	}
+
+	match := idlePattern.FindStringSubmatch(fields[i])
+	if match == nil {
+		return nil
+	}
+
+	hours, _ := strconv.ParseInt(match[1], 10, 64)
+	minutes, _ := strconv.ParseInt(match[2], 10, 64)
+	seconds, _ := strconv.ParseInt(match[3], 10, 64)
+
+	session.Idle = fields[i]
+	session.IdleSeconds = hours*3600 + minutes*60 + seconds
+	i++
+
+	// The user is empty for unauthenticated sessions and may contain spaces, e.g. "Gerrit Code Review"
+	rest := fields[i:]
+	switch len(rest) {
+	case 0:
+	case 1:
+		session.Host = rest[0]
+	default:
+		session.User = strings.Join(rest[:len(rest)-1], " ")
+		session.Host = rest[len(rest)-1]
+	}
+
+	return session
+}
+
+func groupSession(groups map[string]*SessionGroup, name string) *SessionGroup {
+	group, found := groups[name]
+	if !found {
+		group = &SessionGroup{Name: name}
+		groups[name] = group
+	}
+
+	group.Sessions++
+
+	return group
+}
+
+func sortGroups(groups map[string]*SessionGroup) []*SessionGroup {
+	buf := make([]*SessionGroup, 0, len(groups))
+	for _, group := range groups {
+		buf = append(buf, group)
+	}
+
+	sort.Slice(buf, func(i, j int) bool {
+		if buf[i].Sessions != buf[j].Sessions {
+			return buf[i].Sessions > buf[j].Sessions
+		}
+		return buf[i].Name < buf[j].Name
+	})
+
+	return buf
+}
@@ -0,0 +1,43 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConnections(t *testing.T) {
+	for _, name := range []string{
+		"show-connections-2.16",
+		"show-connections-3.9",
+	} {
+		t.Run(name, func(t *testing.T) {
+			connections, err := parseConnections(fixture(t, name+".txt"), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, name+".golden.json", connections)
+		})
+	}
+}
+
+func TestParseConnectionsInvalid(t *testing.T) {
+	for _, output := range []string{
+		"",
+		"fatal: not permitted\n",
+	} {
+		if _, err := parseConnections(output, time.Minute); err == nil {
+			t.Errorf("parseConnections(%q): expected error", output)
+		}
+	}
+}
+
+func TestParseConnectionsIdle(t *testing.T) {
+	connections, err := parseConnections(fixture(t, "show-connections-3.9.txt"), time.Hour*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if connections.Active != connections.Count {
+		t.Errorf("active = %d, want %d", connections.Active, connections.Count)
+	}
+}
@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+const (
+	StrategyBest     = config.StrategyBest
+	StrategyWeighted = config.StrategyWeighted
+	StrategyP2c      = config.StrategyP2c
+
+	Inflight = 30 * time.Second
+)
+
+// inflight counts the recent assignments per site, which are expected to load the site
+// before the next probes can see it.
+type inflight struct {
+	assigned map[string][]time.Time
+	mutex    sync.Mutex
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		assigned: make(map[string][]time.Time),
+	}
+}
+
+func (f *inflight) count(name string, now time.Time, window time.Duration) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	times := f.assigned[name]
+
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= window {
+		i++
+	}
+
+	f.assigned[name] = times[i:]
+
+	return len(times) - i
+}
+
+func (f *inflight) add(name string, now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.assigned[name] = append(f.assigned[name], now)
+}
+
+func (m *Monitor) inflightWindow() time.Duration {
+	if m.config.Select.Inflight > 0 {
+		return m.config.Select.Inflight
+	}
+
+	return Inflight
+}
+
+// distribute moves the site chosen by the strategy first among the ranked sites, and counts
+// it as in flight. Each in-flight assignment weighs as one more connection in the score.
+func (m *Monitor) distribute(sites []*SiteStatus, strategy string, now time.Time) {
+	if strategy != StrategyWeighted && strategy != StrategyP2c {
+		return
+	}
+
+	var scores []float64
+
+	for _, site := range sites {
+		if !site.Healthy {
+			break
+		}
+		site.Inflight = m.inflight.count(site.Name, now, m.inflightWindow())
+		scores = append(scores, float64(site.Score+site.Inflight*Weight))
+	}
+
+	if len(scores) == 0 {
+		return
+	}
+
+	var chosen int
+
+	switch strategy {
+	case StrategyWeighted:
+		chosen = weightedChoice(scores)
+	case StrategyP2c:
+		chosen = twoChoices(scores)
+	}
+
+	site := sites[chosen]
+	copy(sites[1:chosen+1], sites[:chosen])
+	sites[0] = site
+
+	m.inflight.add(site.Name, now)
+	site.Inflight++
+}
+
+// weightedChoice picks an index with probability proportional to the inverse score, with
+// scores shifted to be at least 1 since bonuses can make them negative.
+func weightedChoice(scores []float64) int {
+	low := scores[0]
+	for _, score := range scores {
+		low = min(low, score)
+	}
+
+	shift := 0.0
+	if low < 1 {
+		shift = 1 - low
+	}
+
+	weights := make([]float64, len(scores))
+	total := 0.0
+
+	for i, score := range scores {
+		weights[i] = 1 / (score + shift)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total // nolint:gosec
+
+	for i, weight := range weights {
+		if r < weight {
+			return i
+		}
+		r -= weight
+	}
+
+	return len(scores) - 1
+}
+
+// twoChoices picks two distinct indexes at random and returns the one with the lower score.
+func twoChoices(scores []float64) int {
+	if len(scores) == 1 {
+		return 0
+	}
+
+	a := rand.IntN(len(scores))     // nolint:gosec
+	b := rand.IntN(len(scores) - 1) // nolint:gosec
+	if b >= a {
+		b++
+	}
+
+	if scores[b] < scores[a] || scores[b] == scores[a] && b < a {
+		return b
+	}
+
+	return a
+}
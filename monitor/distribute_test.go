@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+func TestWeightedChoice(t *testing.T) {
+	scores := []float64{10, 20, 40}
+	counts := make([]int, len(scores))
+
+	for i := 0; i < 7000; i++ {
+		counts[weightedChoice(scores)]++
+	}
+
+	// Inverse scores 1/10, 1/20 and 1/40 are 4/7, 2/7 and 1/7
+	for i, want := range []float64{4000, 2000, 1000} {
+		if math.Abs(float64(counts[i])-want) > want*0.15 {
+			t.Errorf("score %v picked %d times, want about %v", scores[i], counts[i], want)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if index := weightedChoice([]float64{-15, -15}); index < 0 || index > 1 {
+			t.Fatalf("negative scores picked %d", index)
+		}
+	}
+}
+
+func TestTwoChoices(t *testing.T) {
+	scores := []float64{10, 20, 40}
+	counts := make([]int, len(scores))
+
+	for i := 0; i < 3000; i++ {
+		counts[twoChoices(scores)]++
+	}
+
+	// The worst site never wins a pair, the best always does
+	if counts[2] != 0 {
+		t.Errorf("worst site picked %d times, want 0", counts[2])
+	}
+
+	if math.Abs(float64(counts[0])-2000) > 300 {
+		t.Errorf("best site picked %d times, want about 2000", counts[0])
+	}
+
+	if twoChoices([]float64{10}) != 0 {
+		t.Error("single site not picked")
+	}
+}
+
+func TestDistribute(t *testing.T) {
+	m := &Monitor{config: &config.Config{}, inflight: newInflight()}
+	now := time.Now()
+
+	counts := make(map[string]int)
+
+	// A burst spreads once the in-flight assignments outweigh the score difference
+	for i := 0; i < 30; i++ {
+		sites := rankedSites(map[string]int{"beijing": 10, "shanghai": 20, "shenzhen": -1})
+		m.distribute(sites, StrategyP2c, now)
+		counts[sites[0].Name]++
+		if len(sites) != 3 {
+			t.Fatalf("distributed %d sites, want 3", len(sites))
+		}
+	}
+
+	if counts["shenzhen"] != 0 {
+		t.Errorf("unhealthy site picked %d times", counts["shenzhen"])
+	}
+
+	if counts["shanghai"] < 10 {
+		t.Errorf("shanghai picked %d times of 30, want the burst spread", counts["shanghai"])
+	}
+
+	sites := rankedSites(map[string]int{"beijing": 10, "shanghai": 20})
+	m.distribute(sites, StrategyBest, now)
+	if sites[0].Name != "beijing" || sites[0].Inflight != 0 {
+		t.Errorf("best strategy picked %s with %d in flight, want beijing untouched", sites[0].Name, sites[0].Inflight)
+	}
+
+	// In-flight assignments expire after the window
+	sites = rankedSites(map[string]int{"beijing": 10, "shanghai": 20})
+	m.distribute(sites, StrategyP2c, now.Add(Inflight))
+	if sites[0].Name != "beijing" || sites[0].Inflight != 1 {
+		t.Errorf("after the window picked %s with %d in flight, want beijing with 1", sites[0].Name, sites[0].Inflight)
+	}
+}
+
+func TestGetRankedSitesPeek(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt"),
+	})
+
+	m.config.Select.Strategy = StrategyP2c
+
+	now := time.Now()
+
+	// Lookups of ranked fallbacks are not selections
+	sites, err := m.GetRankedSites(context.Background(), 2, SelectOptions{Session: "job-1", Peek: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sites[0].Name != "shanghai" {
+		t.Errorf("peeked %s first, want shanghai by score", sites[0].Name)
+	}
+
+	for _, name := range []string{"beijing", "shanghai"} {
+		if count := m.inflight.count(name, now, Inflight); count != 0 {
+			t.Errorf("%s in flight = %d after peek, want 0", name, count)
+		}
+	}
+
+	if len(m.sessions.entries) != 0 {
+		t.Errorf("sessions = %d after peek, want 0", len(m.sessions.entries))
+	}
+
+	if _, err := m.GetRankedSites(context.Background(), 1, SelectOptions{Session: "job-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := m.inflight.count("beijing", now, Inflight) + m.inflight.count("shanghai", now, Inflight); count != 1 {
+		t.Errorf("in flight = %d after selection, want 1", count)
+	}
+}
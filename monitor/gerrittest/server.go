@@ -0,0 +1,365 @@
+package gerrittest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+const (
+	User = "gerrit"
+)
+
+// Response is the scripted result of a gerrit command.
+type Response struct {
+	Output string
+	Stderr string
+	Exit   int
+	// Delay is added before the command responds
+	Delay time.Duration
+	// Hang blocks the command until the session or the server is closed
+	Hang bool
+}
+
+// Server is an in-process Gerrit ssh server answering scripted commands such as
+// "gerrit version", "gerrit show-queue -w" and "gerrit show-connections -w".
+type Server struct {
+	Host string
+	Port int
+
+	// KeyFile is the client private key accepted by the server
+	KeyFile string
+	// KnownHostsFile contains the host key of the server
+	KnownHostsFile string
+
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	responses map[string]Response
+	down      bool
+	conns     map[net.Conn]struct{}
+	closed    chan struct{}
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port, which is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Host:           addr.IP.String(),
+		Port:           addr.Port,
+		KeyFile:        keyFile,
+		KnownHostsFile: knownHostsFile,
+		listener:       listener,
+		responses:      map[string]Response{},
+		conns:          map[net.Conn]struct{}{},
+		closed:         make(chan struct{}),
+	}
+
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() != User || string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return &ssh.Permissions{}, nil
+		},
+	}
+
+	s.config.AddHostKey(hostSigner)
+
+	s.wg.Add(1)
+	go s.serve()
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Handle scripts the response of a command, e.g. Handle("gerrit version", Response{...}).
+// Commands not scripted fail with exit status 1.
+func (s *Server) Handle(command string, response Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.responses[command] = response
+}
+
+// SetDown makes the server drop new connections before the ssh handshake.
+func (s *Server) SetDown(down bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.down = down
+}
+
+// AddRSAHostKey makes the server offer an RSA host key too, and returns a known hosts file
+// holding that key only, as recorded by older OpenSSH clients.
+func (s *Server) AddRSAHostKey(t testing.TB) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, signer.PublicKey())
+	if err := os.WriteFile(name, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections are accepted under the lock, so that they see the new key
+	s.mutex.Lock()
+	s.config.AddHostKey(signer)
+	s.mutex.Unlock()
+
+	return name
+}
+
+// Gerrit returns the site config to reach the server.
+func (s *Server) Gerrit(location string) config.Gerrit {
+	return config.Gerrit{
+		Location: location,
+		Http: config.Http{
+			Url: "http://" + net.JoinHostPort(s.Host, "8080"),
+		},
+		Ssh: config.Ssh{
+			Host: s.Host,
+			Port: s.Port,
+			User: User,
+			Key:  config.KeyFile(s.KeyFile),
+		},
+	}
+}
+
+func (s *Server) Close() {
+	select {
+	case <-s.closed:
+		return
+	default:
+		close(s.closed)
+	}
+
+	_ = s.listener.Close()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		down := s.down
+		if !down {
+			s.conns[conn] = struct{}{}
+		}
+		s.mutex.Unlock()
+
+		if down {
+			_ = conn.Close()
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+
+	defer func() {
+		_ = conn.Close()
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = sshConn.Close()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(channel, requests)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() {
+		_ = channel.Close()
+	}()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct {
+			Command string
+		}
+
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+
+		s.mutex.Lock()
+		response, found := s.responses[payload.Command]
+		s.mutex.Unlock()
+
+		if !found {
+			response = Response{
+				Stderr: "fatal: " + payload.Command + ": not found\n",
+				Exit:   1,
+			}
+		}
+
+		// Stop waiting once the client closes the session
+		done := make(chan struct{})
+		go func() {
+			for range requests {
+			}
+			close(done)
+		}()
+
+		if !s.wait(response, done) {
+			return
+		}
+
+		_, _ = channel.Write([]byte(response.Output))
+		_, _ = channel.Stderr().Write([]byte(response.Stderr))
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct {
+			Status uint32
+		}{uint32(response.Exit)})) // nolint:gosec
+
+		return
+	}
+}
+
+func (s *Server) wait(response Response, done <-chan struct{}) bool {
+	var delay <-chan time.Time
+
+	if !response.Hang {
+		timer := time.NewTimer(response.Delay)
+		defer timer.Stop()
+		delay = timer.C
+	}
+
+	select {
+	case <-delay:
+		return true
+	case <-done:
+		return false
+	case <-s.closed:
+		return false
+	}
+}
+
+// Addr returns the host:port of the server.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
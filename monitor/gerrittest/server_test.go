@@ -0,0 +1,179 @@
+package gerrittest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func dial(t *testing.T, s *Server) (*ssh.Client, error) {
+	t.Helper()
+
+	buf, err := os.ReadFile(s.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := knownhosts.New(s.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+		User:            User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: callback,
+		Timeout:         time.Second,
+	})
+	if err == nil {
+		t.Cleanup(func() {
+			_ = client.Close()
+		})
+	}
+
+	return client, err
+}
+
+// run runs the command in a new session, and returns its output, stderr and exit status.
+func run(t *testing.T, client *ssh.Client, command string) (string, string, int) {
+	t.Helper()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = session.Close()
+	}()
+
+	var stdout, stderr bytes.Buffer
+
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	err = session.Run(command)
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitStatus()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stdout.String(), stderr.String(), 0
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer(t)
+
+	s.Handle("gerrit version", Response{Output: "gerrit version 3.9.1\n"})
+	s.Handle("gerrit show-queue -w", Response{Stderr: "fatal: not permitted\n", Exit: 3})
+
+	client, err := dial(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout, _, exit := run(t, client, "gerrit version"); stdout != "gerrit version 3.9.1\n" || exit != 0 {
+		t.Errorf("version = %q, exit %d, want the scripted output", stdout, exit)
+	}
+
+	if _, stderr, exit := run(t, client, "gerrit show-queue -w"); stderr != "fatal: not permitted\n" || exit != 3 {
+		t.Errorf("show-queue = %q, exit %d, want the scripted error and exit 3", stderr, exit)
+	}
+
+	if _, stderr, exit := run(t, client, "gerrit ls-projects"); !strings.Contains(stderr, "not found") || exit != 1 {
+		t.Errorf("unscripted command = %q, exit %d, want not found and exit 1", stderr, exit)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s := NewServer(t)
+
+	s.Handle("gerrit version", Response{Output: "gerrit version 3.9.1\n", Delay: 200 * time.Millisecond})
+
+	client, err := dial(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	if stdout, _, _ := run(t, client, "gerrit version"); stdout != "gerrit version 3.9.1\n" {
+		t.Errorf("version = %q, want the scripted output", stdout)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("delayed command answered after %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestHang(t *testing.T) {
+	s := NewServer(t)
+
+	s.Handle("gerrit version", Response{Output: "gerrit version 3.9.1\n", Hang: true})
+
+	client, err := dial(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- session.Run("gerrit version")
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("hanging command returned %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Closing the server releases the command
+	s.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("hanging command succeeded, want error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hanging command not released by close")
+	}
+}
+
+func TestSetDown(t *testing.T) {
+	s := NewServer(t)
+
+	s.SetDown(true)
+
+	if _, err := dial(t, s); err == nil {
+		t.Fatal("dial to down server succeeded, want error")
+	}
+
+	s.SetDown(false)
+
+	if _, err := dial(t, s); err != nil {
+		t.Errorf("dial to server up again = %v, want success", err)
+	}
+}
@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+// Usage is the current value against the limits of a site, with the percentage of the hard
+// limit, or of the soft limit without hard limit.
+type Usage struct {
+	Current int     `json:"current"`
+	Soft    int     `json:"soft,omitempty"`
+	Hard    int     `json:"hard,omitempty"`
+	Percent float64 `json:"percent"`
+}
+
+type Utilization struct {
+	Connections *Usage `json:"connections,omitempty"`
+	Queue       *Usage `json:"queue,omitempty"`
+}
+
+func newUsage(current int, limit config.Limit) *Usage {
+	capacity := limit.Hard
+	if capacity <= 0 {
+		capacity = limit.Soft
+	}
+
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &Usage{
+		Current: current,
+		Soft:    limit.Soft,
+		Hard:    limit.Hard,
+		Percent: math.Round(float64(current)*1000/float64(capacity)) / 10,
+	}
+}
+
+// excess returns how far the current value is over the soft limit.
+func (u *Usage) excess() int {
+	if u == nil || u.Soft <= 0 || u.Current <= u.Soft {
+		return 0
+	}
+
+	return u.Current - u.Soft
+}
+
+func (u *Usage) full() bool {
+	return u != nil && u.Hard > 0 && u.Current >= u.Hard
+}
+
+// getUtilization returns the usage of the connections and queue of a site with limits.
+func (m *Monitor) getUtilization(name string, connections, queue int) *Utilization {
+	limits := m.config.Gerrits[name].Limits
+
+	utilization := &Utilization{
+		Connections: newUsage(connections, limits.Connections),
+		Queue:       newUsage(queue, limits.Queue),
+	}
+
+	if utilization.Connections == nil && utilization.Queue == nil {
+		return nil
+	}
+
+	return utilization
+}
+
+// penalty adds a connection weight to the score for each connection or queued task over the
+// soft limits.
+func (u *Utilization) penalty() int {
+	if u == nil {
+		return 0
+	}
+
+	return (u.Connections.excess() + u.Queue.excess()) * Weight
+}
+
+// check fails if a hard limit is reached, which excludes the site from selection.
+func (u *Utilization) check() error {
+	if u == nil {
+		return nil
+	}
+
+	if u.Connections.full() {
+		return errors.Errorf("connections %d reached hard limit %d", u.Connections.Current, u.Connections.Hard)
+	}
+
+	if u.Queue.full() {
+		return errors.Errorf("queue size %d reached hard limit %d", u.Queue.Current, u.Queue.Hard)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+func TestUtilization(t *testing.T) {
+	m := &Monitor{config: &config.Config{Gerrits: map[string]config.Gerrit{
+		"beijing": {Limits: config.Limits{
+			Connections: config.Limit{Soft: 4, Hard: 8},
+			Queue:       config.Limit{Soft: 20},
+		}},
+		"shanghai": {},
+	}}}
+
+	if u := m.getUtilization("shanghai", 100, 100); u != nil || u.penalty() != 0 || u.check() != nil {
+		t.Errorf("utilization without limits = %v, want none", u)
+	}
+
+	u := m.getUtilization("beijing", 6, 5)
+	if u.Connections.Percent != 75 || u.Queue.Percent != 25 {
+		t.Errorf("percent = %v, %v, want 75, 25", u.Connections.Percent, u.Queue.Percent)
+	}
+
+	if got, want := u.penalty(), 2*Weight; got != want {
+		t.Errorf("penalty = %d, want %d", got, want)
+	}
+
+	if err := u.check(); err != nil {
+		t.Errorf("check = %v, want below the hard limit", err)
+	}
+
+	if err := m.getUtilization("beijing", 8, 0).check(); err == nil {
+		t.Error("check passed at the hard limit")
+	}
+}
+
+func TestGetSiteStatusLimits(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+	})
+
+	unlimited := m.GetSiteStatus(context.Background(), "shanghai")
+
+	beijing := m.config.Gerrits["beijing"]
+	beijing.Limits.Connections = config.Limit{Soft: 4}
+	m.config.Gerrits["beijing"] = beijing
+
+	status := m.GetSiteStatus(context.Background(), "beijing")
+	if !status.Healthy || status.Score <= unlimited.Score {
+		t.Errorf("over soft limit = healthy %t, score %d, want healthy with a score above %d", status.Healthy, status.Score, unlimited.Score)
+	}
+
+	beijing.Limits.Queue = config.Limit{Hard: 7}
+	m.config.Gerrits["beijing"] = beijing
+
+	status = m.GetSiteStatus(context.Background(), "beijing")
+	if status.Healthy || !strings.Contains(status.Error, "queue size 7 reached hard limit 7") {
+		t.Errorf("at hard limit = healthy %t, error %q, want excluded", status.Healthy, status.Error)
+	}
+
+	if status.Utilization.Queue.Percent != 100 {
+		t.Errorf("queue utilization = %v%%, want 100%%", status.Utilization.Queue.Percent)
+	}
+}
@@ -1,55 +1,130 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
-	"os/exec"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/utils"
 )
 
 const (
 	siteName = "gerrit"
 
+	// Sentinels of unreachable sites, see the limits of the sites for capacity
 	ConnectionMax = 65536
 	QueueMax      = 65536
 	Weight        = 10
+
+	Interval    = time.Minute
+	Timeout     = 10 * time.Second
+	Idle        = time.Minute
+	Concurrency = 8
 )
 
 type SiteStatus struct {
-	Name         string    `json:"name"`
-	Location     string    `json:"location"`
-	Url          string    `json:"url"`
-	Host         string    `json:"host"`
-	Healthy      bool      `json:"healthy"`
-	ResponseTime int64     `json:"responseTime"`
-	Connections  int       `json:"connections"`
-	QueueSize    int       `json:"queueSize"`
-	Score        int       `json:"score"`
-	LastCheck    time.Time `json:"lastCheck"`
-	Error        string    `json:"error"`
+	Name              string       `json:"name"`
+	Location          string       `json:"location"`
+	Url               string       `json:"url"`
+	Host              string       `json:"host"`
+	Clusters          []string     `json:"clusters,omitempty"`
+	Healthy           bool         `json:"healthy"`
+	ResponseTime      int64        `json:"responseTime"`
+	Connections       int          `json:"connections"`
+	ActiveConnections int          `json:"activeConnections"`
+	QueueSize         int          `json:"queueSize"`
+	QueueRunning      int          `json:"queueRunning"`
+	QueueWaiting      int          `json:"queueWaiting"`
+	QueueReplication  int          `json:"queueReplication"`
+	Score             int          `json:"score"`
+	LastCheck         time.Time    `json:"lastCheck"`
+	Error             string       `json:"error"`
+	Throughput        *Throughput  `json:"throughput,omitempty"`
+	Smoothed          *Smoothed    `json:"smoothed,omitempty"`
+	Degraded          bool         `json:"degraded"`
+	Inflight          int          `json:"inflight"`
+	Utilization       *Utilization `json:"utilization,omitempty"`
+}
+
+type SelectOptions struct {
+	AllowDegraded bool
+	// Session keeps returning the same healthy site for the session until its ttl expires
+	Session string
+	// Group restricts the selection to the sites of a cluster
+	Group string
+	// Peek ranks the sites without assigning one, neither to the session nor as in flight
+	Peek bool
+}
+
+type NoHealthySiteError struct {
+	Reasons map[string]string
+}
+
+func (e *NoHealthySiteError) Error() string {
+	names := make([]string, 0, len(e.Reasons))
+	for name := range e.Reasons {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, e.Reasons[name]))
+	}
+
+	return "no healthy site available (" + strings.Join(reasons, "; ") + ")"
+}
+
+type Stats struct {
+	Healthy       int           `json:"healthy"`
+	Unhealthy     int           `json:"unhealthy"`
+	Unknown       int           `json:"unknown"`
+	Cycles        int64         `json:"cycles"`
+	LastCycle     time.Time     `json:"lastCycle"`
+	CycleDuration time.Duration `json:"cycleDuration"`
+	Lag           time.Duration `json:"lag"`
+	Interval      time.Duration `json:"interval"`
 }
 
 type Monitor struct {
-	config   *config.Config
-	sites    map[string]*SiteStatus
-	mutex    sync.RWMutex
-	client   *http.Client
-	testMode bool
+	config     *config.Config
+	sites      map[string]*SiteStatus
+	mutex      sync.RWMutex
+	client     *http.Client
+	stats      Stats
+	statsMutex sync.RWMutex
+	semaphore  chan struct{}
+	prober     prober
+
+	throughput      map[string]*Throughput
+	measuring       map[string]bool
+	throughputMutex sync.Mutex
+	smoother        *smoother
+	sessions        *sessionStore
+	inflight        *inflight
+
+	// Statuses of the last probe cycle of Run, which answer queries once available
+	latest      map[string]*SiteStatus
+	latestMutex sync.RWMutex
+	running     atomic.Bool
 }
 
 func NewMonitor(cfg *config.Config) *Monitor {
 	m := &Monitor{
-		config:   cfg,
-		sites:    make(map[string]*SiteStatus),
-		client:   &http.Client{Timeout: 10 * time.Second},
-		testMode: false,
+		config: cfg,
+		sites:  make(map[string]*SiteStatus),
+		client: &http.Client{Timeout: 10 * time.Second},
 	}
 
 	m.initializeMonitor()
@@ -57,49 +132,229 @@ func NewMonitor(cfg *config.Config) *Monitor {
 	return m
 }
 
-func NewTestMonitor(cfg *config.Config) *Monitor {
-	m := &Monitor{
-		config:   cfg,
-		sites:    make(map[string]*SiteStatus),
-		client:   &http.Client{Timeout: 10 * time.Second},
-		testMode: true,
-	}
-
-	m.initializeMonitor()
+// NewSimulationMonitor probes the sites of the scenario instead of real sites.
+func NewSimulationMonitor(cfg *config.Config, scenario *Scenario) *Monitor {
+	m := NewMonitor(cfg)
+	m.prober = newSimProber(scenario)
 
 	return m
 }
 
 func (m *Monitor) initializeMonitor() {
+	concurrency := m.config.Monitor.Concurrency
+	if concurrency <= 0 {
+		concurrency = Concurrency
+	}
+
+	m.semaphore = make(chan struct{}, concurrency)
+	m.throughput = make(map[string]*Throughput)
+	m.measuring = make(map[string]bool)
+	m.smoother = newSmoother()
+	m.sessions = newSessionStore(m.config.Session.File, m.config.Session.Ttl)
+	m.inflight = newInflight()
+	m.prober = &sshProber{config: m.config}
+
 	for key, val := range m.config.Gerrits {
 		m.sites[key] = &SiteStatus{
 			Name:     key,
 			Location: val.Location,
-			Url:      val.Http.Url,
+			Url:      utils.RedactUrl(val.Http.Url),
 			Host:     val.Ssh.Host,
 		}
 	}
 }
 
-func (m *Monitor) GetAllSitesStatus() []*SiteStatus {
+func (m *Monitor) GetAllSitesStatus(ctx context.Context) []*SiteStatus {
+	names, _ := m.groupSites("")
+
+	if sites := m.lastCycle(names); sites != nil {
+		return sites
+	}
+
+	return m.getSitesStatus(ctx, names, false)
+}
+
+// lastCycle returns copies of the statuses of the sites from the last probe cycle of Run, nil
+// until the first cycle completes.
+func (m *Monitor) lastCycle(names []string) []*SiteStatus {
+	m.latestMutex.RLock()
+	defer m.latestMutex.RUnlock()
+
+	if m.latest == nil {
+		return nil
+	}
+
+	sites := make([]*SiteStatus, 0, len(names))
+
+	for _, name := range names {
+		if status, found := m.latest[name]; found {
+			site := *status
+			sites = append(sites, &site)
+		}
+	}
+
+	return sites
+}
+
+func (m *Monitor) setLastCycle(sites []*SiteStatus) {
+	latest := make(map[string]*SiteStatus, len(sites))
+
+	for _, site := range sites {
+		status := *site
+		latest[site.Name] = &status
+	}
+
+	m.latestMutex.Lock()
+	m.latest = latest
+	m.latestMutex.Unlock()
+}
+
+// getSitesStatus probes the sites, and updates the moving averages of their scores for the probe
+// cycles of Run only, so that smoothing does not depend on the rate of queries.
+func (m *Monitor) getSitesStatus(ctx context.Context, names []string, cycle bool) []*SiteStatus {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	// Return test data if in test mode
-	if m.testMode {
-		return GetTestSitesData()
+	return m.probeAll(ctx, names, cycle)
+}
+
+func (m *Monitor) GetRankedSites(ctx context.Context, n int, opts SelectOptions) ([]*SiteStatus, error) {
+	names, err := m.groupSites(opts.Group)
+	if err != nil {
+		return nil, err
 	}
 
-	sites := make([]*SiteStatus, 0, len(m.sites))
-	for _, site := range m.sites {
-		s := m.getSiteStatus(site.Name)
-		sites = append(sites, s)
+	if len(names) == 0 {
+		return nil, errors.New("no sites available\n")
 	}
 
-	return sites
+	sites := m.lastCycle(names)
+	cycled := sites != nil
+	if !cycled {
+		sites = m.getSitesStatus(ctx, names, false)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rankSites(sites)
+
+	if smoothing := m.config.Monitor.Smoothing; smoothing.Alpha > 0 || smoothing.Margin > 0 || smoothing.Hold > 0 {
+		if cycled {
+			m.smoother.pick(opts.Group, sites, smoothing.Margin, smoothing.Hold, time.Now())
+		} else if !m.running.Load() {
+			slog.Warn("smoothing only applies to the probe cycles of serve, ignored")
+		}
+	}
+
+	// A session keeps its site, otherwise the site picked by the strategy is assigned to it
+	session := opts.Session
+	if session != "" && opts.Group != "" {
+		session = opts.Group + "/" + session
+	}
+
+	if session != "" && m.config.Session.File == "" && !m.running.Load() {
+		slog.Warn("sessions are kept in memory only, set session.file to keep them across queries")
+	}
+
+	release := m.sessions.acquire(session)
+
+	if now := time.Now(); !m.sessions.lookup(session, sites, now) && !opts.Peek {
+		m.distribute(sites, m.strategy(opts.Group), now)
+		m.sessions.assign(session, sites, now)
+	}
+
+	release()
+
+	if n > 0 && n < len(sites) {
+		sites = sites[:n]
+	}
+
+	return sites, nil
+}
+
+func (m *Monitor) Run(ctx context.Context, handler func(context.Context, []*SiteStatus)) {
+	interval := m.interval()
+
+	m.running.Store(true)
+	defer m.running.Store(false)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	scheduled := time.Now()
+
+	for {
+		start := time.Now()
+		names, _ := m.groupSites("")
+		sites := m.getSitesStatus(ctx, names, true)
+		if ctx.Err() != nil {
+			return
+		}
+		m.setLastCycle(sites)
+		m.updateStats(sites, start, start.Sub(scheduled))
+		m.refreshThroughput(ctx, sites)
+
+		if handler != nil {
+			handler(ctx, sites)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case scheduled = <-ticker.C:
+		}
+	}
 }
 
-func (m *Monitor) GetSiteStatus(name string) *SiteStatus {
+func (m *Monitor) GetStats() Stats {
+	m.statsMutex.RLock()
+	defer m.statsMutex.RUnlock()
+
+	stats := m.stats
+	stats.Interval = m.interval()
+
+	if stats.Cycles == 0 {
+		stats.Unknown = len(m.sites)
+	}
+
+	return stats
+}
+
+func (m *Monitor) interval() time.Duration {
+	if m.config.Monitor.Interval > 0 {
+		return m.config.Monitor.Interval
+	}
+
+	return Interval
+}
+
+func (m *Monitor) updateStats(sites []*SiteStatus, start time.Time, lag time.Duration) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	m.stats.Healthy = 0
+	m.stats.Unhealthy = 0
+
+	for _, site := range sites {
+		if site.Healthy {
+			m.stats.Healthy++
+		} else {
+			m.stats.Unhealthy++
+		}
+	}
+
+	m.stats.Unknown = len(m.sites) - len(sites)
+	if m.stats.Unknown < 0 {
+		m.stats.Unknown = 0
+	}
+
+	m.stats.Cycles++
+	m.stats.LastCycle = start
+	m.stats.CycleDuration = time.Since(start)
+	m.stats.Lag = lag
+}
+
+func (m *Monitor) GetSiteStatus(ctx context.Context, name string) *SiteStatus {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
@@ -107,7 +362,7 @@ func (m *Monitor) GetSiteStatus(name string) *SiteStatus {
 
 	for _, site := range m.sites {
 		if site.Name == name {
-			status = m.getSiteStatus(site.Name)
+			status = m.getSiteStatus(ctx, site.Name, false)
 			break
 		}
 	}
@@ -115,12 +370,11 @@ func (m *Monitor) GetSiteStatus(name string) *SiteStatus {
 	return status
 }
 
-func (m *Monitor) GetSiteHealth(name string) map[string]interface{} {
+func (m *Monitor) GetSiteHealth(ctx context.Context, name string) map[string]interface{} {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	_, err := m.getResponseTime(name)
-	if err != nil {
+	if _, err := m.probe(ctx, name); err != nil {
 		return map[string]interface{}{
 			"healthy": false,
 		}
@@ -131,210 +385,265 @@ func (m *Monitor) GetSiteHealth(name string) map[string]interface{} {
 	}
 }
 
-func (m *Monitor) GetSiteQueues(name string) map[string]interface{} {
+func (m *Monitor) GetSiteQueues(ctx context.Context, name string) (*Queue, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	queue, err := m.getQueue(name)
+	result, err := m.probe(ctx, name)
 	if err != nil {
-		return map[string]interface{}{}
+		return nil, err
 	}
 
-	return map[string]interface{}{
-		"queueSize": queue,
-	}
+	return parseQueue(result.Queue)
 }
 
-func (m *Monitor) GetSiteConnections(name string) map[string]interface{} {
+func (m *Monitor) GetSiteConnections(ctx context.Context, name string) (*Connections, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	connections, err := m.getConnection(name)
+	result, err := m.probe(ctx, name)
 	if err != nil {
-		return map[string]interface{}{}
+		return nil, err
 	}
 
-	return map[string]interface{}{
-		"connections": connections,
-	}
+	return parseConnections(result.Connections, m.idle())
 }
 
-func (m *Monitor) GetAvailableSite() (*SiteStatus, error) {
-	var bestSite *SiteStatus
+func (m *Monitor) GetAvailableSite(ctx context.Context, opts SelectOptions) (*SiteStatus, error) {
+	sites, err := m.GetRankedSites(ctx, 0, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(m.sites) == 0 {
-		return nil, errors.New("no sites available\n")
+	if sites[0].Healthy {
+		return sites[0], nil
 	}
 
-	siteChan := make(chan *SiteStatus, len(m.sites))
-	activeGoroutines := 0
+	reasons := make(map[string]string, len(sites))
+	for _, site := range sites {
+		reasons[site.Name] = site.Error
+	}
 
-	for _, site := range m.sites {
-		if bestSite != nil {
-			if bestSite.Name == site.Name {
-				continue
-			}
-		} else {
-			bestSite = site
+	if !opts.AllowDegraded {
+		return nil, &NoHealthySiteError{Reasons: reasons}
+	}
+
+	sort.SliceStable(sites, func(i, j int) bool {
+		a, b := sites[i], sites[j]
+		if (a.ResponseTime >= 0) != (b.ResponseTime >= 0) {
+			return a.ResponseTime >= 0
 		}
-		activeGoroutines++
-		go func(site *SiteStatus) {
-			siteChan <- m.getSiteStatus(site.Name)
-		}(site)
+		if a.ResponseTime != b.ResponseTime {
+			return a.ResponseTime < b.ResponseTime
+		}
+		return a.Name < b.Name
+	})
+
+	site := *sites[0]
+	site.Degraded = true
+
+	slog.Warn("no healthy site, returning degraded site", "site", site.Name, "error", site.Error)
+
+	return &site, nil
+}
+
+func (m *Monitor) probeAll(ctx context.Context, names []string, cycle bool) []*SiteStatus {
+	siteChan := make(chan *SiteStatus, len(names))
+
+	for _, name := range names {
+		go func(name string) {
+			siteChan <- m.getSiteStatus(ctx, name, cycle)
+		}(name)
 	}
 
-	bestScore := ConnectionMax*Weight + QueueMax + 1000 // High fallback score
+	sites := make([]*SiteStatus, 0, len(names))
+	for range names {
+		sites = append(sites, <-siteChan)
+	}
 
-	for i := 0; i < activeGoroutines; i++ {
-		site := <-siteChan
-		if site.Error == "" && site.Score < bestScore {
-			bestSite = site
-			bestScore = site.Score
+	return sites
+}
+
+// rankSites orders healthy sites by score, then unhealthy sites, breaking ties by name.
+func rankSites(sites []*SiteStatus) {
+	sort.SliceStable(sites, func(i, j int) bool {
+		a, b := sites[i], sites[j]
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		if a.Healthy && a.Score != b.Score {
+			return a.Score < b.Score
 		}
+		return a.Name < b.Name
+	})
+}
+
+// acquire waits for a probe slot and returns a context bounded by the timeout.
+func (m *Monitor) acquire(ctx context.Context, timeout time.Duration) (context.Context, func(), error) {
+	select {
+	case m.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
 	}
 
-	return bestSite, nil
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		<-m.semaphore
+	}, nil
 }
 
-func (m *Monitor) getResponseTime(name string) (float64, error) {
-	cmd := exec.Command("ssh", "-p", strconv.Itoa(m.config.Gerrits[name].Ssh.Port), "-i", m.config.Gerrits[name].Ssh.Key, "-o", "ConnectTimeout=5", fmt.Sprintf("%s@%s", m.config.Gerrits[name].Ssh.User, m.config.Gerrits[name].Ssh.Host), siteName, "version")
+func (m *Monitor) timeout() time.Duration {
+	if m.config.Monitor.Timeout > 0 {
+		return m.config.Monitor.Timeout
+	}
 
-	start := time.Now()
-	err := cmd.Run()
-	elapsed := time.Since(start)
+	return Timeout
+}
 
-	if err != nil {
-		return 1000.0, err // High penalty for unreachable sites
+func (m *Monitor) idle() time.Duration {
+	if m.config.Monitor.Idle > 0 {
+		return m.config.Monitor.Idle
 	}
 
-	return float64(elapsed.Nanoseconds()) / 1000000.0, nil // Convert to milliseconds
+	return Idle
 }
 
-func (m *Monitor) getSiteStatus(name string) *SiteStatus {
-	type result struct {
-		connections int
-		queue       int
-		connErr     error
-		queueErr    error
+func (m *Monitor) probe(ctx context.Context, name string) (*Probe, error) {
+	if _, found := m.config.Gerrits[name]; !found {
+		return nil, errors.Errorf("site %s not found", name)
 	}
 
-	ch := make(chan result, 1)
-
-	go func() {
-		connections, connErr := m.getConnection(name)
-		queue, queueErr := m.getQueue(name)
+	if err := m.prober.prepare(ctx, name); err != nil {
+		return nil, err
+	}
 
-		ch <- result{
-			connections: connections,
-			queue:       queue,
-			connErr:     connErr,
-			queueErr:    queueErr,
-		}
-	}()
-
-	res := <-ch
-	if res.connErr != nil || res.queueErr != nil {
-		return &SiteStatus{
-			Name:         name,
-			Location:     m.sites[name].Location,
-			Url:          m.sites[name].Url,
-			Host:         m.sites[name].Host,
-			Healthy:      false,
-			ResponseTime: -1,
-			Connections:  ConnectionMax,
-			QueueSize:    QueueMax,
-			Score:        -1,
-			LastCheck:    time.Now(),
-			Error:        fmt.Sprintf("failed to get status for site %s", name),
-		}
+	ctx, release, err := m.acquire(ctx, m.timeout())
+	if err != nil {
+		return nil, err
 	}
 
-	responseTime, _ := m.getResponseTime(name)
-	score := m.calculateScore(name, res.connections, res.queue)
+	defer release()
+
+	return m.prober.probe(ctx, name)
+}
 
-	return &SiteStatus{
+func (m *Monitor) getSiteStatus(ctx context.Context, name string, cycle bool) *SiteStatus {
+	status := &SiteStatus{
 		Name:         name,
 		Location:     m.sites[name].Location,
 		Url:          m.sites[name].Url,
 		Host:         m.sites[name].Host,
-		Healthy:      true,
-		ResponseTime: int64(responseTime),
-		Connections:  res.connections,
-		QueueSize:    res.queue,
-		Score:        score,
+		Clusters:     m.clusters(name),
+		Healthy:      false,
+		ResponseTime: -1,
+		Connections:  ConnectionMax,
+		QueueSize:    QueueMax,
+		Score:        -1,
 		LastCheck:    time.Now(),
-		Error:        "",
 	}
-}
 
-func (m *Monitor) getQueue(name string) (int, error) {
-	cmd := exec.Command("ssh", "-p", strconv.Itoa(m.config.Gerrits[name].Ssh.Port), "-i", m.config.Gerrits[name].Ssh.Key, fmt.Sprintf("%s@%s", m.config.Gerrits[name].Ssh.User, m.config.Gerrits[name].Ssh.Host), siteName, "version")
-	if err := cmd.Run(); err != nil {
-		return QueueMax, nil
+	result, err := m.probe(ctx, name)
+	if result == nil {
+		if cycle {
+			m.smoother.reset(name)
+		}
+		slog.Warn("site unreachable", "site", name, "error", err)
+		status.Error = fmt.Sprintf("site %s unreachable: %v", name, err)
+		return status
+	}
+
+	status.ResponseTime = result.Latency.Milliseconds()
+
+	var connections *Connections
+	var queue *Queue
+
+	if err == nil {
+		connections, err = parseConnections(result.Connections, m.idle())
+	}
+
+	if err == nil {
+		queue, err = parseQueue(result.Queue)
 	}
 
-	cmd = exec.Command("ssh", "-p", strconv.Itoa(m.config.Gerrits[name].Ssh.Port), "-i", m.config.Gerrits[name].Ssh.Key, fmt.Sprintf("%s@%s", m.config.Gerrits[name].Ssh.User, m.config.Gerrits[name].Ssh.Host), siteName, "show-queue", "-w")
-	output, err := cmd.Output()
 	if err != nil {
-		return QueueMax, nil
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "tasks") && !strings.Contains(line, "waiting") {
-			fields := strings.Fields(line)
-			if len(fields) > 0 {
-				if queue, err := strconv.Atoi(fields[0]); err == nil {
-					return queue, nil
-				}
-			}
+		if cycle {
+			m.smoother.reset(name)
 		}
+		slog.Warn("failed to get site status", "site", name, "error", err)
+		status.Connections = ConnectionMax
+		status.QueueSize = QueueMax
+		status.Error = fmt.Sprintf("failed to get status for site %s: %v", name, err)
+		return status
 	}
 
-	return QueueMax, nil
-}
+	status.Healthy = true
+	status.Connections = connections.Count
+	status.ActiveConnections = connections.Active
+	status.QueueSize = queue.Size
+	status.QueueRunning = queue.Running
+	status.QueueWaiting = queue.Waiting
+	status.QueueReplication = queue.Replication
+	status.Throughput = m.getThroughput(name)
+
+	connectionLoad, queueLoad, latency := m.getConnectionLoad(connections), m.getQueueLoad(queue), result.Latency
 
-func (m *Monitor) getConnection(name string) (int, error) {
-	cmd := exec.Command("ssh", "-p", strconv.Itoa(m.config.Gerrits[name].Ssh.Port), "-i", m.config.Gerrits[name].Ssh.Key, fmt.Sprintf("%s@%s", m.config.Gerrits[name].Ssh.User, m.config.Gerrits[name].Ssh.Host), siteName, "version")
-	if err := cmd.Run(); err != nil {
-		return ConnectionMax, nil
+	var smoothed *Smoothed
+	if cycle {
+		connectionLoad, queueLoad, latency, smoothed = m.smooth(name, connectionLoad, queueLoad, latency)
 	}
 
-	cmd = exec.Command("ssh", "-p", strconv.Itoa(m.config.Gerrits[name].Ssh.Port), "-i", m.config.Gerrits[name].Ssh.Key, fmt.Sprintf("%s@%s", m.config.Gerrits[name].Ssh.User, m.config.Gerrits[name].Ssh.Host), siteName, "show-connections", "-w")
-	output, err := cmd.Output()
-	if err != nil {
-		return ConnectionMax, nil
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "connections") {
-			parts := strings.Split(line, ":")
-			if len(parts) > 0 {
-				fields := strings.Fields(parts[0])
-				if len(fields) > 0 {
-					if connection, err := strconv.Atoi(fields[0]); err == nil {
-						return connection, nil
-					}
-				}
-			}
-		}
+	status.Smoothed = smoothed
+	status.Utilization = m.getUtilization(name, connections.Count, queue.Size)
+	status.Score = m.calculateScore(name, connectionLoad, queueLoad, latency, status.Throughput) + status.Utilization.penalty()
+
+	if err := status.Utilization.check(); err != nil {
+		slog.Warn("site over capacity", "site", name, "error", err)
+		status.Healthy = false
+		status.Error = fmt.Sprintf("site %s over capacity: %v", name, err)
 	}
 
-	return ConnectionMax, nil
+	return status
 }
 
-func (m *Monitor) getLatencyPenalty(name string) int {
-	// Add penalty based on network latency for remote sites
-	latency, _ := m.getResponseTime(name)
-
+func (m *Monitor) getLatencyPenalty(latency time.Duration) int {
 	// Convert latency to penalty (higher latency = higher penalty)
 	// Latency in milliseconds, penalty multiplier
-	penalty := int(latency * 0.1) // 10ms latency = 1 point penalty
+	penalty := int(float64(latency.Nanoseconds()) / 1000000.0 * 0.1) // 10ms latency = 1 point penalty
 
 	return penalty
 }
 
+// getConnectionLoad counts only active connections if configured, so that idle sessions kept open
+// by clients do not penalize a site.
+func (m *Monitor) getConnectionLoad(connections *Connections) int {
+	if m.config.Monitor.Score.Active {
+		return connections.Active
+	}
+
+	return connections.Count
+}
+
+// getQueueLoad weighs running, waiting and replication tasks, counting unlisted tasks as is.
+func (m *Monitor) getQueueLoad(queue *Queue) int {
+	weights := m.config.Monitor.Score
+	if weights.Running == 0 && weights.Waiting == 0 && weights.Replication == 0 {
+		weights.Running, weights.Waiting, weights.Replication = 1, 1, 1
+	}
+
+	load := float64(queue.Size - len(queue.Tasks))
+	if load < 0 {
+		load = 0
+	}
+
+	load += float64(queue.Running)*weights.Running +
+		float64(queue.Waiting)*weights.Waiting +
+		float64(queue.Replication)*weights.Replication
+
+	return int(math.Round(load))
+}
+
 func (m *Monitor) getConnectionEfficiency(connections int) int {
 	// Lower connections are better, but add diminishing returns
 	if connections == 0 {
@@ -361,11 +670,12 @@ func (m *Monitor) getQueueEfficiency(queue int) int {
 	}
 }
 
-func (m *Monitor) calculateScore(name string, connections, queue int) int {
+func (m *Monitor) calculateScore(name string, connections, queue int, latency time.Duration, throughput *Throughput) int {
 	// Calculate base score using the original Weight constant
 	baseScore := connections*Weight + queue
 
-	latencyPenalty := m.getLatencyPenalty(name)
+	latencyPenalty := m.getLatencyPenalty(latency)
+	throughputPenalty := m.getThroughputPenalty(throughput)
 	connectionEfficiency := m.getConnectionEfficiency(connections)
 	queueEfficiency := m.getQueueEfficiency(queue)
 
@@ -378,7 +688,7 @@ func (m *Monitor) calculateScore(name string, connections, queue int) int {
 	// Calculate total score and apply site importance as a multiplier
 	// Higher importance (closer to 1.0) = lower final score (more preferred)
 	// Lower importance (closer to 0.0) = higher final score (less preferred)
-	totalScore := baseScore + latencyPenalty + connectionEfficiency + queueEfficiency
+	totalScore := baseScore + latencyPenalty + throughputPenalty + connectionEfficiency + queueEfficiency
 
 	// Apply importance factor: invert it so higher importance gives lower score
 	finalScore := int(float32(totalScore) / siteImportance)
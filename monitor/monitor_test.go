@@ -1 +1,326 @@
 package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+
+	buf, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf)
+}
+
+func golden(t *testing.T, name string, data interface{}) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got = append(got, '\n')
+	file := filepath.Join("testdata", name)
+
+	if *update {
+		if err := os.WriteFile(file, got, 0o644); err != nil { // nolint:gosec
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run go test -update to regenerate)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// newSite starts a fake site serving the given fixtures.
+func newSite(t *testing.T, queue, connections string) *gerrittest.Server {
+	t.Helper()
+
+	s := gerrittest.NewServer(t)
+	s.Handle("gerrit version", gerrittest.Response{Output: fixture(t, "version-3.9.txt")})
+	s.Handle("gerrit show-queue -w", gerrittest.Response{Output: fixture(t, queue)})
+	s.Handle("gerrit show-connections -w", gerrittest.Response{Output: fixture(t, connections)})
+
+	return s
+}
+
+func newMonitor(t *testing.T, sites map[string]*gerrittest.Server) *Monitor {
+	t.Helper()
+
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{},
+		Monitor: config.Monitor{
+			Timeout: 2 * time.Second,
+		},
+	}
+
+	// Every fake site has its own host key, so combine their known hosts files
+	var knownHosts []byte
+
+	for name, site := range sites {
+		cfg.Gerrits[name] = site.Gerrit(name)
+		buf, err := os.ReadFile(site.KnownHostsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		knownHosts = append(knownHosts, buf...)
+	}
+
+	cfg.Monitor.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(cfg.Monitor.KnownHosts, knownHosts, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMonitor(cfg)
+}
+
+func TestGetSiteStatus(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing": newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+	})
+
+	status := m.GetSiteStatus(context.Background(), "beijing")
+
+	if !status.Healthy {
+		t.Fatalf("expected healthy site, got error %q", status.Error)
+	}
+
+	if status.Connections != 6 || status.ActiveConnections != 5 {
+		t.Errorf("connections = %d/%d, want 6/5", status.Connections, status.ActiveConnections)
+	}
+
+	if status.QueueSize != 7 || status.QueueRunning != 2 || status.QueueWaiting != 3 || status.QueueReplication != 2 {
+		t.Errorf("queue = %d (%d running, %d waiting, %d replication), want 7 (2, 3, 2)",
+			status.QueueSize, status.QueueRunning, status.QueueWaiting, status.QueueReplication)
+	}
+
+	if status.Score <= 0 {
+		t.Errorf("score = %d, want positive", status.Score)
+	}
+}
+
+func TestGetSiteStatusLatency(t *testing.T) {
+	site := newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt")
+	site.Handle("gerrit version", gerrittest.Response{Output: "gerrit version 3.9.1\n", Delay: 200 * time.Millisecond})
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	status := m.GetSiteStatus(context.Background(), "beijing")
+
+	if !status.Healthy || status.ResponseTime < 200 {
+		t.Errorf("healthy = %t, response time = %dms, want healthy with at least 200ms", status.Healthy, status.ResponseTime)
+	}
+}
+
+func TestGetSiteStatusFailure(t *testing.T) {
+	tests := map[string]func(s *gerrittest.Server){
+		"down": func(s *gerrittest.Server) {
+			s.SetDown(true)
+		},
+		"version": func(s *gerrittest.Server) {
+			s.Handle("gerrit version", gerrittest.Response{Stderr: "fatal: not permitted\n", Exit: 1})
+		},
+		"queue": func(s *gerrittest.Server) {
+			s.Handle("gerrit show-queue -w", gerrittest.Response{Stderr: "fatal: not permitted\n", Exit: 1})
+		},
+		"connections": func(s *gerrittest.Server) {
+			s.Handle("gerrit show-connections -w", gerrittest.Response{Output: "garbage\n"})
+		},
+		"hang": func(s *gerrittest.Server) {
+			s.Handle("gerrit show-queue -w", gerrittest.Response{Hang: true})
+		},
+	}
+
+	for name, inject := range tests {
+		t.Run(name, func(t *testing.T) {
+			site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+			inject(site)
+
+			m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+			m.config.Monitor.Timeout = 500 * time.Millisecond
+
+			start := time.Now()
+
+			status := m.GetSiteStatus(context.Background(), "beijing")
+
+			if status.Healthy || status.Error == "" {
+				t.Errorf("healthy = %t, error = %q, want unhealthy with error", status.Healthy, status.Error)
+			}
+
+			if status.Connections != ConnectionMax || status.QueueSize != QueueMax {
+				t.Errorf("connections = %d, queue = %d, want maximum", status.Connections, status.QueueSize)
+			}
+
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("probe took %s, want within timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestGetRankedSites(t *testing.T) {
+	down := newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt")
+	down.SetDown(true)
+
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt"),
+		"shenzhen": down,
+	})
+
+	sites, err := m.GetRankedSites(context.Background(), 0, SelectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, site := range sites {
+		names = append(names, site.Name)
+	}
+
+	if got, want := strings.Join(names, ","), "shanghai,beijing,shenzhen"; got != want {
+		t.Errorf("ranked sites = %s, want %s", got, want)
+	}
+
+	sites, err = m.GetRankedSites(context.Background(), 1, SelectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sites) != 1 || sites[0].Name != "shanghai" {
+		t.Errorf("top site = %v, want shanghai", sites)
+	}
+}
+
+func TestGetRankedSitesLastCycle(t *testing.T) {
+	shanghai := newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt")
+
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": shanghai,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cycle := make(chan struct{}, 1)
+
+	go m.Run(ctx, func(context.Context, []*SiteStatus) {
+		select {
+		case cycle <- struct{}{}:
+		default:
+		}
+	})
+
+	<-cycle
+
+	// Queries answer from the last cycle without probing the sites again
+	shanghai.SetDown(true)
+
+	for i := 0; i < 2; i++ {
+		sites, err := m.GetRankedSites(context.Background(), 1, SelectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sites[0].Name != "shanghai" || !sites[0].Healthy {
+			t.Errorf("top site = %s (healthy %t), want shanghai from the last cycle", sites[0].Name, sites[0].Healthy)
+		}
+	}
+
+	if sites := m.GetAllSitesStatus(context.Background()); len(sites) != 2 {
+		t.Errorf("sites = %d, want 2 from the last cycle", len(sites))
+	}
+}
+
+func TestGetAvailableSite(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+		"shanghai": newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt"),
+	})
+
+	site, err := m.GetAvailableSite(context.Background(), SelectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "shanghai" || site.Degraded {
+		t.Errorf("available site = %s (degraded %t), want shanghai", site.Name, site.Degraded)
+	}
+}
+
+func TestGetAvailableSiteUnhealthy(t *testing.T) {
+	beijing := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+	beijing.Handle("gerrit show-queue -w", gerrittest.Response{Exit: 1})
+
+	shanghai := newSite(t, "show-queue-empty.txt", "show-connections-2.16.txt")
+	shanghai.SetDown(true)
+
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing":  beijing,
+		"shanghai": shanghai,
+	})
+
+	_, err := m.GetAvailableSite(context.Background(), SelectOptions{})
+
+	var noHealthy *NoHealthySiteError
+	if !errors.As(err, &noHealthy) {
+		t.Fatalf("error = %v, want NoHealthySiteError", err)
+	}
+
+	if len(noHealthy.Reasons) != 2 {
+		t.Errorf("reasons = %v, want one per site", noHealthy.Reasons)
+	}
+
+	site, err := m.GetAvailableSite(context.Background(), SelectOptions{AllowDegraded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !site.Degraded {
+		t.Errorf("site %s not flagged as degraded", site.Name)
+	}
+}
+
+func TestScoreWeights(t *testing.T) {
+	queue, err := parseQueue(fixture(t, "show-queue-3.9.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Monitor{config: &config.Config{}}
+
+	if got := m.getQueueLoad(queue); got != queue.Size {
+		t.Errorf("default queue load = %d, want %d", got, queue.Size)
+	}
+
+	m.config.Monitor.Score = config.Score{Running: 1, Waiting: 2}
+
+	if got, want := m.getQueueLoad(queue), queue.Running+2*queue.Waiting; got != want {
+		t.Errorf("weighted queue load = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,321 @@
+package monitor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	knownHostsFile = "~/.ssh/known_hosts"
+	agentSocket    = "SSH_AUTH_SOCK"
+)
+
+type Probe struct {
+	Version     string
+	Latency     time.Duration
+	Queue       string
+	Connections string
+}
+
+type prober interface {
+	// prepare loads the credentials of the site before probes, outside of their timeout
+	prepare(ctx context.Context, name string) error
+	probe(ctx context.Context, name string) (*Probe, error)
+	throughput(ctx context.Context, name string) (*Throughput, error)
+}
+
+type sshProber struct {
+	config  *config.Config
+	signers map[config.KeyFile]ssh.Signer
+	mutex   sync.Mutex
+}
+
+// prepare parses the key of the site, whose decryption may take longer than a probe.
+func (p *sshProber) prepare(ctx context.Context, name string) error {
+	site := p.config.Gerrits[name]
+	if site.Ssh.Key == "" {
+		return nil
+	}
+
+	_, err := p.signer(ctx, site.Ssh)
+
+	return err
+}
+
+// probe opens one ssh connection to the site, measures latency with gerrit version and
+// runs the remaining gerrit commands over the same connection. The returned probe is
+// nil if the site is unreachable, and partially filled if a later command fails.
+func (p *sshProber) probe(ctx context.Context, name string) (*Probe, error) {
+	site := p.config.Gerrits[name]
+
+	client, err := p.dial(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// Close the connection on cancellation to interrupt running commands
+	stop := context.AfterFunc(ctx, func() {
+		_ = client.Close()
+	})
+	defer stop()
+
+	version, latency, err := run(ctx, client, name, siteName, "version")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Probe{
+		Version: strings.TrimSpace(version),
+		Latency: latency,
+	}
+
+	if result.Queue, _, err = run(ctx, client, name, siteName, "show-queue", "-w"); err != nil {
+		return result, errors.Wrap(err, "failed to show queue")
+	}
+
+	if result.Connections, _, err = run(ctx, client, name, siteName, "show-connections", "-w"); err != nil {
+		return result, errors.Wrap(err, "failed to show connections")
+	}
+
+	return result, nil
+}
+
+func (p *sshProber) dial(ctx context.Context, site config.Gerrit) (*ssh.Client, error) {
+	auth, release, err := p.auth(ctx, site.Ssh)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	hostKeyCallback, err := p.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User:            site.Ssh.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	if deadline, found := ctx.Deadline(); found {
+		clientConfig.Timeout = time.Until(deadline)
+	}
+
+	addr := net.JoinHostPort(site.Ssh.Host, strconv.Itoa(site.Ssh.Port))
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, found := ctx.Deadline(); found {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if !p.config.Monitor.Insecure {
+		clientConfig.HostKeyAlgorithms = hostKeyAlgorithms(hostKeyCallback, addr, conn.RemoteAddr())
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// auth authenticates with the key of the site, and with the ssh agent of SSH_AUTH_SOCK if
+// enabled or without key. The agent connection is closed by release after the handshake.
+func (p *sshProber) auth(ctx context.Context, cfg config.Ssh) ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+
+	release := func() {}
+
+	if cfg.Key != "" {
+		signer, err := p.signer(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if sock := os.Getenv(agentSocket); sock != "" && (cfg.Agent || cfg.Key == "") {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to connect to ssh agent")
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		release = func() {
+			_ = conn.Close()
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, errors.Errorf("no ssh key configured and %s not set", agentSocket)
+	}
+
+	return methods, release, nil
+}
+
+// signer parses the key of the site once, from its file or from the content of its secret
+// reference, decrypted with the passphrase if set.
+func (p *sshProber) signer(ctx context.Context, cfg config.Ssh) (ssh.Signer, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if signer, found := p.signers[cfg.Key]; found {
+		return signer, nil
+	}
+
+	content, err := cfg.Key.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := []byte(content)
+
+	var signer ssh.Signer
+
+	if cfg.Passphrase != "" {
+		passphrase, e := cfg.Passphrase.Resolve(ctx)
+		if e != nil {
+			return nil, e
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(buf)
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.Errorf("key %s is encrypted, set ssh.passphrase", cfg.Key)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key %s", cfg.Key)
+	}
+
+	if p.signers == nil {
+		p.signers = make(map[config.KeyFile]ssh.Signer)
+	}
+
+	p.signers[cfg.Key] = signer
+
+	return signer, nil
+}
+
+// hostKeyCallback verifies site host keys against the known hosts file, and fails closed if
+// it does not exist unless host key verification is explicitly disabled.
+func (p *sshProber) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if p.config.Monitor.Insecure {
+		return ssh.InsecureIgnoreHostKey(), nil // nolint:gosec
+	}
+
+	name := p.config.Monitor.KnownHosts
+	if name == "" {
+		name = knownHostsFile
+	}
+
+	name = utils.ExpandTilde(name)
+
+	if _, err := os.Stat(name); err != nil {
+		return nil, errors.Errorf("known hosts file %s not found, set monitor.knownHosts or monitor.insecure", name)
+	}
+
+	callback, err := knownhosts.New(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read known hosts file %s", name)
+	}
+
+	return callback, nil
+}
+
+// hostKeyAlgorithms returns the algorithms of the known host keys of the address, so that the
+// server does not negotiate a key type missing from the known hosts file, e.g. RSA while only
+// the ed25519 key is known. It returns nil for unknown hosts.
+func hostKeyAlgorithms(callback ssh.HostKeyCallback, addr string, remote net.Addr) []string {
+	// A key which is never known reports the known keys of the host
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(addr, remote, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+
+	return algorithms
+}
+
+func run(ctx context.Context, client *ssh.Client, name string, command ...string) (string, time.Duration, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer func() {
+		_ = session.Close()
+	}()
+
+	start := time.Now()
+	output, err := session.Output(strings.Join(command, " "))
+	elapsed := time.Since(start)
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitStatus()
+		} else {
+			exitCode = -1
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+
+	slog.Debug("probe",
+		"site", name,
+		"command", strings.Join(command, " "),
+		"duration", elapsed,
+		"exit", exitCode,
+		"error", err)
+
+	return string(output), elapsed, err
+}
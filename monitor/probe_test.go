@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+func rawKey(t *testing.T, name string) interface{} {
+	t.Helper()
+
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.ParseRawPrivateKey(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestProbePassphrase(t *testing.T) {
+	site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(rawKey(t, site.KeyFile), "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(encrypted, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	beijing := m.config.Gerrits["beijing"]
+	beijing.Ssh.Key = config.KeyFile(encrypted)
+	m.config.Gerrits["beijing"] = beijing
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); status.Healthy || !strings.Contains(status.Error, "key "+encrypted+" is encrypted") {
+		t.Errorf("without passphrase = healthy %t, error %q, want encrypted key error", status.Healthy, status.Error)
+	}
+
+	t.Setenv("PROXY_TEST_PASSPHRASE", "secret")
+
+	beijing.Ssh.Passphrase = "env:PROXY_TEST_PASSPHRASE"
+	m.config.Gerrits["beijing"] = beijing
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); !status.Healthy {
+		t.Errorf("with passphrase = unhealthy, error %q", status.Error)
+	}
+}
+
+func TestProbeKeyReference(t *testing.T) {
+	site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+
+	buf, err := os.ReadFile(site.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PROXY_TEST_KEY", string(buf))
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	beijing := m.config.Gerrits["beijing"]
+	beijing.Ssh.Key = "env:PROXY_TEST_KEY"
+	m.config.Gerrits["beijing"] = beijing
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); !status.Healthy {
+		t.Errorf("key from environment = unhealthy, error %q", status.Error)
+	}
+}
+
+func TestProbeAgent(t *testing.T) {
+	site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: rawKey(t, site.KeyFile)}); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	beijing := m.config.Gerrits["beijing"]
+	beijing.Ssh.Key = ""
+	m.config.Gerrits["beijing"] = beijing
+
+	t.Setenv(agentSocket, "")
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); status.Healthy || !strings.Contains(status.Error, agentSocket) {
+		t.Errorf("without key nor agent = healthy %t, error %q, want agent error", status.Healthy, status.Error)
+	}
+
+	t.Setenv(agentSocket, sock)
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); !status.Healthy {
+		t.Errorf("with agent = unhealthy, error %q", status.Error)
+	}
+}
+
+func TestProbeHostKey(t *testing.T) {
+	site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+	other := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	m.config.Monitor.KnownHosts = filepath.Join(t.TempDir(), "missing")
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); status.Healthy || !strings.Contains(status.Error, "known hosts file") {
+		t.Errorf("missing known hosts = healthy %t, error %q, want known hosts error", status.Healthy, status.Error)
+	}
+
+	// The host key of another server under the address of the site
+	buf, err := os.ReadFile(other.KnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatched := strings.Replace(string(buf), strconv.Itoa(other.Port), strconv.Itoa(site.Port), 1)
+
+	m.config.Monitor.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(m.config.Monitor.KnownHosts, []byte(mismatched), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); status.Healthy || !strings.Contains(status.Error, "key mismatch") {
+		t.Errorf("mismatched host key = healthy %t, error %q, want key mismatch", status.Healthy, status.Error)
+	}
+
+	m.config.Monitor.Insecure = true
+
+	if status := m.GetSiteStatus(context.Background(), "beijing"); !status.Healthy {
+		t.Errorf("insecure = unhealthy, error %q", status.Error)
+	}
+}
+
+func TestProbeHostKeyAlgorithms(t *testing.T) {
+	site := newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt")
+
+	m := newMonitor(t, map[string]*gerrittest.Server{"beijing": site})
+
+	// The server offers an ed25519 and an RSA host key, each known hosts file has one of them
+	rsaKnownHosts := site.AddRSAHostKey(t)
+
+	for _, name := range []string{site.KnownHostsFile, rsaKnownHosts} {
+		m.config.Monitor.KnownHosts = name
+
+		if status := m.GetSiteStatus(context.Background(), "beijing"); !status.Healthy {
+			t.Errorf("known hosts %s = unhealthy, error %q", name, status.Error)
+		}
+	}
+}
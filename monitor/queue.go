@@ -0,0 +1,197 @@
+package monitor
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	StateRunning  = "running"
+	StateWaiting  = "waiting"
+	StateSleeping = "sleeping"
+	StateStarting = "starting"
+	StateStopping = "stopping"
+	StateParked   = "parked"
+	StateDone     = "done"
+	StateKilled   = "killed"
+	StateUnknown  = "unknown"
+)
+
+var (
+	timePattern    = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(\.\d{3})?$`)
+	datePattern    = regexp.MustCompile(`^[A-Z][a-z]{2}-\d{2}$`)
+	minutePattern  = regexp.MustCompile(`^\d{2}:\d{2}$`)
+	taskPattern    = regexp.MustCompile(`^[0-9a-f]{8}$`)
+	summaryPattern = regexp.MustCompile(`^(\d+)\s+tasks?\b`)
+	projectPattern = regexp.MustCompile(`'/?([^']+?)(\.git)?'`)
+	pushPattern    = regexp.MustCompile(`\bpush\s+(\S+)`)
+
+	replicationPattern = regexp.MustCompile(`^(\(retry[^)]*\)\s*)?(\[[^\]]*\]\s*)?push\s`)
+)
+
+type Task struct {
+	Id          string `json:"id"`
+	State       string `json:"state"`
+	StartTime   string `json:"startTime"`
+	Command     string `json:"command"`
+	Project     string `json:"project"`
+	Queue       string `json:"queue"`
+	Replication bool   `json:"replication"`
+}
+
+type Queue struct {
+	Size        int     `json:"size"`
+	Running     int     `json:"running"`
+	Waiting     int     `json:"waiting"`
+	Replication int     `json:"replication"`
+	Tasks       []*Task `json:"tasks"`
+}
+
+// parseQueue parses the output of gerrit show-queue -w, with or without the StartTime
+// column and the "Queue:" sections printed by newer Gerrit versions.
+func parseQueue(output string) (*Queue, error) {
+	var queueName string
+
+	queue := &Queue{Size: -1, Tasks: []*Task{}}
+	hasStartTime := false
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "---"):
+			continue
+		case strings.HasPrefix(trimmed, "Task "):
+			hasStartTime = strings.Contains(trimmed, "StartTime")
+			continue
+		case strings.HasPrefix(trimmed, "Queue:"):
+			queueName = strings.TrimSpace(strings.TrimPrefix(trimmed, "Queue:"))
+			continue
+		}
+
+		if match := summaryPattern.FindStringSubmatch(trimmed); match != nil {
+			queue.Size, _ = strconv.Atoi(match[1])
+			continue
+		}
+
+		if task := parseTask(trimmed, hasStartTime); task != nil {
+			task.Queue = queueName
+			task.Replication = isReplication(task)
+			queue.Tasks = append(queue.Tasks, task)
+		}
+	}
+
+	if queue.Size < 0 {
+		return nil, errors.New("failed to parse queue")
+	}
+
+	for _, task := range queue.Tasks {
+		switch {
+		case task.State == StateDone || task.State == StateKilled:
+			continue
+		case task.Replication:
+			queue.Replication++
+		case task.State == StateRunning || task.State == StateStarting || task.State == StateStopping:
+			queue.Running++
+		default:
+			queue.Waiting++
+		}
+	}
+
+	return queue, nil
+}
+
+func parseTask(line string, hasStartTime bool) *Task {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !taskPattern.MatchString(fields[0]) {
+		return nil
+	}
+
+	task := &Task{Id: fields[0]}
+	i := 1
+
+	// Skip the dots used to align state names, e.g. "waiting ....", "....... done"
+	skipDots := func() {
+		for i < len(fields) && strings.Trim(fields[i], ".") == "" {
+			i++
+		}
+	}
+
+	skipDots()
+
+	if i < len(fields) {
+		switch fields[i] {
+		case StateWaiting, StateStarting, StateStopping, StateParked, StateRunning, StateDone, StateKilled:
+			task.State = fields[i]
+			i++
+		case "?":
+			task.State = StateUnknown
+			i++
+		}
+	}
+
+	skipDots()
+
+	var times []string
+	for i < len(fields) && len(times) < 2 {
+		if timePattern.MatchString(fields[i]) {
+			times = append(times, fields[i])
+			i++
+		} else if i+1 < len(fields) && datePattern.MatchString(fields[i]) && minutePattern.MatchString(fields[i+1]) {
+			// Times older than a day are printed as "MMM-dd HH:mm"
+			times = append(times, fields[i]+" "+fields[i+1])
+			i += 2
+		} else {
+			break
+		}
+	}
+
+	// A leading time is the next run of a sleeping task, followed by the start time if present
+	switch {
+	case len(times) == 2:
+		task.State = StateSleeping
+		task.StartTime = times[1]
+	case len(times) == 1 && hasStartTime:
+		task.StartTime = times[0]
+	case len(times) == 1:
+		task.State = StateSleeping
+	}
+
+	if task.State == "" {
+		task.State = StateRunning
+	}
+
+	task.Command = strings.Join(fields[i:], " ")
+	task.Project = parseProject(task.Command)
+
+	return task
+}
+
+func parseProject(command string) string {
+	if match := projectPattern.FindStringSubmatch(command); match != nil {
+		return match[1]
+	}
+
+	if match := pushPattern.FindStringSubmatch(command); match != nil {
+		target := match[1]
+		if index := strings.Index(target, "://"); index >= 0 {
+			target = target[index+3:]
+			if index = strings.Index(target, "/"); index >= 0 {
+				target = target[index+1:]
+			}
+		} else if index := strings.Index(target, ":"); index >= 0 {
+			target = target[index+1:]
+		}
+		return strings.TrimSuffix(strings.Trim(target, "/"), ".git")
+	}
+
+	return ""
+}
+
+func isReplication(task *Task) bool {
+	return strings.Contains(task.Command, "[replication]") ||
+		strings.Contains(strings.ToLower(task.Queue), "replicat") ||
+		replicationPattern.MatchString(task.Command)
+}
@@ -0,0 +1,50 @@
+package monitor
+
+import (
+	"testing"
+)
+
+func TestParseQueue(t *testing.T) {
+	for _, name := range []string{
+		"show-queue-2.16",
+		"show-queue-3.4",
+		"show-queue-3.9",
+		"show-queue-empty",
+	} {
+		t.Run(name, func(t *testing.T) {
+			queue, err := parseQueue(fixture(t, name+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, name+".golden.json", queue)
+		})
+	}
+}
+
+func TestParseQueueInvalid(t *testing.T) {
+	for _, output := range []string{
+		"",
+		"fatal: not permitted\n",
+		"Task     State        StartTime         Command\n",
+	} {
+		if _, err := parseQueue(output); err == nil {
+			t.Errorf("parseQueue(%q): expected error", output)
+		}
+	}
+}
+
+func TestParseProject(t *testing.T) {
+	tests := map[string]string{
+		"git-upload-pack '/platform/build' (jdoe)":                      "platform/build",
+		"git-upload-pack '/platform/build.git' (jdoe)":                  "platform/build",
+		"[8c4d2e1f] push ssh://mirror.example.com/platform/build.git":   "platform/build",
+		"(retry 2) [0a1b2c3d] push git@mirror.example.com:platform/art": "platform/art",
+		"Log File Compressor": "",
+	}
+
+	for command, want := range tests {
+		if got := parseProject(command); got != want {
+			t.Errorf("parseProject(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
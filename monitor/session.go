@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	SessionTtl = time.Hour
+)
+
+type sessionEntry struct {
+	Site    string    `json:"site"`
+	Expires time.Time `json:"expires"`
+}
+
+// sessionStore keeps the site assigned to each session until the ttl expires, in memory and
+// in a file if set, so that the assignment is shared between processes under a file lock.
+type sessionStore struct {
+	file    string
+	ttl     time.Duration
+	entries map[string]sessionEntry
+	mutex   sync.Mutex
+}
+
+func newSessionStore(file string, ttl time.Duration) *sessionStore {
+	if ttl <= 0 {
+		ttl = SessionTtl
+	}
+
+	return &sessionStore{
+		file:    file,
+		ttl:     ttl,
+		entries: make(map[string]sessionEntry),
+	}
+}
+
+// acquire locks the store for a lookup and its assignment, against the other processes sharing
+// the file too, and loads the file.
+func (s *sessionStore) acquire(session string) func() {
+	if session == "" {
+		return func() {}
+	}
+
+	s.mutex.Lock()
+
+	unlock := s.lockFile()
+
+	s.load()
+
+	return func() {
+		unlock()
+		s.mutex.Unlock()
+	}
+}
+
+func (s *sessionStore) lockFile() func() {
+	if s.file == "" {
+		return func() {}
+	}
+
+	name := utils.ExpandTilde(s.file)
+
+	if err := os.MkdirAll(filepath.Dir(name), utils.PermDir); err != nil {
+		slog.Warn("failed to lock sessions", "file", s.file, "error", err)
+		return func() {}
+	}
+
+	file, err := os.OpenFile(name+".lock", os.O_CREATE|os.O_RDWR, utils.PermFile)
+	if err != nil {
+		slog.Warn("failed to lock sessions", "file", s.file, "error", err)
+		return func() {}
+	}
+
+	if err := lockFile(file); err != nil {
+		slog.Warn("failed to lock sessions", "file", s.file, "error", err)
+	}
+
+	return func() {
+		_ = unlockFile(file)
+		_ = file.Close()
+	}
+}
+
+// lookup moves the site assigned to the session first among the ranked sites, and reports
+// whether the assignment is still valid and healthy. The store must be acquired.
+func (s *sessionStore) lookup(session string, sites []*SiteStatus, now time.Time) bool {
+	if session == "" {
+		return false
+	}
+
+	entry, found := s.entries[session]
+	if !found || !now.Before(entry.Expires) {
+		return false
+	}
+
+	for i, site := range sites {
+		if site.Name == entry.Site && site.Healthy {
+			copy(sites[1:i+1], sites[:i])
+			sites[0] = site
+			return true
+		}
+	}
+
+	return false
+}
+
+// assign assigns the first site to the session if healthy. The store must be acquired.
+func (s *sessionStore) assign(session string, sites []*SiteStatus, now time.Time) {
+	if session == "" || len(sites) == 0 || !sites[0].Healthy {
+		return
+	}
+
+	s.entries[session] = sessionEntry{
+		Site:    sites[0].Name,
+		Expires: now.Add(s.ttl),
+	}
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.Expires) {
+			delete(s.entries, key)
+		}
+	}
+
+	if err := s.save(); err != nil {
+		slog.Warn("failed to save sessions", "file", s.file, "error", err)
+	}
+}
+
+func (s *sessionStore) load() {
+	if s.file == "" {
+		return
+	}
+
+	buf, err := os.ReadFile(utils.ExpandTilde(s.file))
+	if err != nil {
+		return
+	}
+
+	entries := make(map[string]sessionEntry)
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		slog.Warn("invalid sessions", "file", s.file, "error", err)
+		return
+	}
+
+	s.entries = entries
+}
+
+func (s *sessionStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	name := utils.ExpandTilde(s.file)
+
+	if err := os.MkdirAll(filepath.Dir(name), utils.PermDir); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so that readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), utils.PermFile); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return errors.Wrap(err, "failed to rename sessions")
+	}
+
+	return nil
+}
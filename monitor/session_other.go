@@ -0,0 +1,17 @@
+//go:build !unix
+
+package monitor
+
+import (
+	"os"
+)
+
+// Sessions are not locked against other processes without flock, the rename of the file still
+// keeps it whole.
+func lockFile(*os.File) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
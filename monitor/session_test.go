@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+func rankedSites(scores map[string]int) []*SiteStatus {
+	var sites []*SiteStatus
+
+	for name, score := range scores {
+		sites = append(sites, &SiteStatus{Name: name, Healthy: score >= 0, Score: score})
+	}
+
+	rankSites(sites)
+
+	return sites
+}
+
+func newSessionMonitor(file string) *Monitor {
+	return NewMonitor(&config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"beijing":  {},
+			"shanghai": {},
+		},
+		Session: config.Session{File: file, Ttl: time.Hour},
+	})
+}
+
+// selectSession returns the site selected for the session, with the scores as last probe cycle.
+func selectSession(t *testing.T, m *Monitor, session string, scores map[string]int) string {
+	t.Helper()
+
+	m.setLastCycle(rankedSites(scores))
+
+	site, err := m.GetAvailableSite(context.Background(), SelectOptions{Session: session})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return site.Name
+}
+
+func TestSessionStick(t *testing.T) {
+	m := newSessionMonitor("")
+
+	steps := []struct {
+		session string
+		scores  map[string]int
+		expire  bool
+		want    string
+	}{
+		{"job-1", map[string]int{"beijing": 10, "shanghai": 20}, false, "beijing"},
+		{"job-1", map[string]int{"beijing": 30, "shanghai": 20}, false, "beijing"},
+		{"job-2", map[string]int{"beijing": 30, "shanghai": 20}, false, "shanghai"},
+		{"", map[string]int{"beijing": 30, "shanghai": 20}, false, "shanghai"},
+		// Reassigned once unhealthy
+		{"job-1", map[string]int{"beijing": -1, "shanghai": 20}, false, "shanghai"},
+		{"job-1", map[string]int{"beijing": 10, "shanghai": 20}, false, "shanghai"},
+		// Reassigned once expired
+		{"job-1", map[string]int{"beijing": 10, "shanghai": 20}, true, "beijing"},
+	}
+
+	for i, step := range steps {
+		if step.expire {
+			entry := m.sessions.entries[step.session]
+			entry.Expires = time.Now()
+			m.sessions.entries[step.session] = entry
+		}
+		if got := selectSession(t, m, step.session, step.scores); got != step.want {
+			t.Errorf("step %d: %s got %s, want %s", i, step.session, got, step.want)
+		}
+	}
+}
+
+func TestSessionPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions", "proxy.sessions")
+
+	selectSession(t, newSessionMonitor(file), "job-1", map[string]int{"beijing": 10, "shanghai": 20})
+
+	if got := selectSession(t, newSessionMonitor(file), "job-1", map[string]int{"beijing": 30, "shanghai": 20}); got != "beijing" {
+		t.Errorf("site = %s, want beijing from the persisted session", got)
+	}
+}
+
+func TestSessionConcurrent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxy.sessions")
+
+	// Monitors of separate processes sharing the file keep each other's assignments
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := newSessionMonitor(file)
+			m.setLastCycle(rankedSites(map[string]int{"beijing": 10, "shanghai": 20}))
+			if _, err := m.GetAvailableSite(context.Background(), SelectOptions{Session: fmt.Sprintf("job-%d", i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	s := newSessionStore(file, time.Hour)
+	release := s.acquire("job-0")
+	defer release()
+
+	if len(s.entries) != 20 {
+		t.Errorf("sessions = %d, want 20", len(s.entries))
+	}
+}
@@ -0,0 +1,354 @@
+package monitor
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+const (
+	OutageDown  = "down"
+	OutageHang  = "hang"
+	OutageError = "error"
+)
+
+//go:embed scenario.yaml
+var scenarioData []byte
+
+// Scenario drives the simulation prober, so that the server works fully offline.
+type Scenario struct {
+	Seed     uint64                    `yaml:"seed"`
+	Sites    map[string]ScenarioSite   `yaml:"sites"`
+	Clusters map[string]config.Cluster `yaml:"clusters"`
+}
+
+type ScenarioSite struct {
+	Location    string        `yaml:"location"`
+	Url         string        `yaml:"url"`
+	Host        string        `yaml:"host"`
+	Weight      float32       `yaml:"weight"`
+	Latency     Distribution  `yaml:"latency"`
+	Queue       Distribution  `yaml:"queue"`
+	Connections Distribution  `yaml:"connections"`
+	Throughput  Distribution  `yaml:"throughput"`
+	Outages     []Outage      `yaml:"outages"`
+	Limits      config.Limits `yaml:"limits"`
+}
+
+// Distribution is uniform between min and max, or normal if stddev is set and clamped to
+// min and max. Latency is in milliseconds and throughput in KiB/s.
+type Distribution struct {
+	Min    float64 `yaml:"min"`
+	Max    float64 `yaml:"max"`
+	Mean   float64 `yaml:"mean"`
+	Stddev float64 `yaml:"stddev"`
+}
+
+// Outage starts at the given offset from the start of the simulation and lasts for duration,
+// forever if not set. It repeats every period if set.
+type Outage struct {
+	Start    time.Duration `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+	Every    time.Duration `yaml:"every"`
+	Mode     string        `yaml:"mode"`
+}
+
+// LoadScenario loads a scenario file, or the built-in scenario if name is empty.
+func LoadScenario(name string) (*Scenario, error) {
+	data := scenarioData
+
+	if name != "" {
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read scenario\n")
+		}
+		data = buf
+	}
+
+	var scenario Scenario
+
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		return nil, errors.Wrap(err, "failed to parse scenario\n")
+	}
+
+	for name, site := range scenario.Sites {
+		for _, outage := range site.Outages {
+			switch outage.Mode {
+			case "", OutageDown, OutageHang, OutageError:
+			default:
+				return nil, errors.Errorf("invalid outage mode %s of site %s\n", outage.Mode, name)
+			}
+		}
+	}
+
+	return &scenario, nil
+}
+
+// Apply limits the config to the simulated sites, adding the sites and clusters missing from
+// it, so that configured sites which are not simulated are skipped.
+func (s *Scenario) Apply(cfg *config.Config) {
+	if cfg.Gerrits == nil {
+		cfg.Gerrits = map[string]config.Gerrit{}
+	}
+
+	if cfg.Clusters == nil {
+		cfg.Clusters = map[string]config.Cluster{}
+	}
+
+	for name := range cfg.Gerrits {
+		if _, found := s.Sites[name]; !found {
+			delete(cfg.Gerrits, name)
+		}
+	}
+
+	for name, cluster := range cfg.Clusters {
+		var sites []string
+		for _, site := range cluster.Sites {
+			if _, found := s.Sites[site]; found {
+				sites = append(sites, site)
+			}
+		}
+		if len(sites) == 0 {
+			delete(cfg.Clusters, name)
+			continue
+		}
+		cluster.Sites = sites
+		cfg.Clusters[name] = cluster
+	}
+
+	for name, cluster := range s.Clusters {
+		if _, found := cfg.Clusters[name]; !found {
+			cfg.Clusters[name] = cluster
+		}
+	}
+
+	for name, site := range s.Sites {
+		if _, found := cfg.Gerrits[name]; found {
+			continue
+		}
+		cfg.Gerrits[name] = config.Gerrit{
+			Location: site.Location,
+			Weight:   site.Weight,
+			Http:     config.Http{Url: site.Url},
+			Ssh:      config.Ssh{Host: site.Host, Port: 29418},
+			Limits:   site.Limits,
+		}
+	}
+}
+
+func (o *Outage) active(elapsed time.Duration) bool {
+	if elapsed < o.Start {
+		return false
+	}
+
+	elapsed -= o.Start
+	if o.Every > 0 {
+		elapsed %= o.Every
+	}
+
+	return o.Duration <= 0 || elapsed < o.Duration
+}
+
+type simProber struct {
+	scenario *Scenario
+	start    time.Time
+	rand     *rand.Rand
+	mutex    sync.Mutex
+}
+
+func newSimProber(scenario *Scenario) *simProber {
+	seed := scenario.Seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano()) // nolint:gosec
+	}
+
+	return &simProber{
+		scenario: scenario,
+		start:    time.Now(),
+		rand:     rand.New(rand.NewPCG(seed, seed)), // nolint:gosec
+	}
+}
+
+// prepare has no key to parse, simulated sites need no authentication.
+func (p *simProber) prepare(context.Context, string) error {
+	return nil
+}
+
+// probe renders gerrit outputs from the scenario, so that they go through the same parsers
+// and scoring as real sites.
+func (p *simProber) probe(ctx context.Context, name string) (*Probe, error) {
+	site, found := p.scenario.Sites[name]
+	if !found {
+		return nil, errors.Errorf("site %s not simulated", name)
+	}
+
+	mode := p.outage(site)
+
+	switch mode {
+	case OutageDown:
+		return nil, errors.Errorf("dial tcp %s: connection refused (simulated)", site.Host)
+	case OutageHang:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	p.mutex.Lock()
+	latency := time.Duration(p.sample(site.Latency) * float64(time.Millisecond))
+	queue := int(p.sample(site.Queue))
+	connections := int(p.sample(site.Connections))
+	p.mutex.Unlock()
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+	}
+
+	result := &Probe{
+		Version: "gerrit version 3.9.1",
+		Latency: latency,
+	}
+
+	if mode == OutageError {
+		return result, errors.New("failed to show queue: fatal: simulated error")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result.Queue = p.renderQueue(queue)
+	result.Connections = p.renderConnections(connections)
+
+	return result, nil
+}
+
+// throughput simulates a shallow fetch of 256 KiB at the sampled rate, 1 MiB/s by default.
+func (p *simProber) throughput(_ context.Context, name string) (*Throughput, error) {
+	site, found := p.scenario.Sites[name]
+	if !found {
+		return nil, errors.Errorf("site %s not simulated", name)
+	}
+
+	if mode := p.outage(site); mode != "" {
+		return nil, errors.Errorf("failed to ls-remote: %s (simulated)", mode)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	latency := p.sample(site.Latency)
+	rate := p.sample(site.Throughput)
+	if rate <= 0 {
+		rate = 1024
+	}
+
+	result := &Throughput{
+		LsRemote:       int64(latency * 2),
+		Ttfb:           int64(latency * 3),
+		Bytes:          256 << 10,
+		BytesPerSecond: int64(rate * 1024),
+	}
+
+	return result, nil
+}
+
+func (p *simProber) outage(site ScenarioSite) string {
+	for i := range site.Outages {
+		if site.Outages[i].active(time.Since(p.start)) {
+			if site.Outages[i].Mode == "" {
+				return OutageDown
+			}
+			return site.Outages[i].Mode
+		}
+	}
+
+	return ""
+}
+
+func (p *simProber) sample(d Distribution) float64 {
+	var value float64
+
+	if d.Stddev > 0 {
+		value = p.rand.NormFloat64()*d.Stddev + d.Mean
+	} else if d.Max > d.Min {
+		value = d.Min + p.rand.Float64()*(d.Max-d.Min)
+	} else {
+		value = math.Max(d.Min, d.Mean)
+	}
+
+	value = math.Max(value, d.Min)
+	if d.Max > 0 {
+		value = math.Min(value, d.Max)
+	}
+
+	return math.Max(value, 0)
+}
+
+func (p *simProber) renderQueue(size int) string {
+	var b strings.Builder
+
+	now := time.Now()
+
+	b.WriteString("Task     State        StartTime         Command\n")
+	b.WriteString(strings.Repeat("-", 78) + "\n")
+
+	for i := 0; i < size; i++ {
+		start := now.Add(-time.Duration(p.rand.IntN(60000)) * time.Millisecond).Format("15:04:05.000")
+		project := simProjects[p.rand.IntN(len(simProjects))]
+		switch {
+		case i%4 == 3:
+			_, _ = fmt.Fprintf(&b, "%08x waiting .... %s      [%08x] push ssh://mirror.example.com/%s.git\n", p.rand.Uint32(), start, p.rand.Uint32(), project)
+		case i%2 == 0:
+			_, _ = fmt.Fprintf(&b, "%08x              %s      git-upload-pack '/%s' (%s)\n", p.rand.Uint32(), start, project, simUsers[p.rand.IntN(len(simUsers))])
+		default:
+			_, _ = fmt.Fprintf(&b, "%08x waiting .... %s      git-upload-pack '/%s' (%s)\n", p.rand.Uint32(), start, project, simUsers[p.rand.IntN(len(simUsers))])
+		}
+	}
+
+	b.WriteString(strings.Repeat("-", 78) + "\n")
+	_, _ = fmt.Fprintf(&b, "  %d tasks, 8 worker threads\n", size)
+
+	return b.String()
+}
+
+func (p *simProber) renderConnections(count int) string {
+	var b strings.Builder
+
+	now := time.Now()
+
+	b.WriteString("Session     Start     Idle   User              Remote Host\n")
+	b.WriteString(strings.Repeat("-", 62) + "\n")
+
+	for i := 0; i < count; i++ {
+		start := now.Add(-time.Duration(p.rand.IntN(3600)) * time.Second).Format("15:04:05")
+		idle := time.Duration(p.rand.IntN(300)) * time.Second
+		_, _ = fmt.Fprintf(&b, "%08x %s %02d:%02d:%02d  %-16s  %s\n", p.rand.Uint32(), start,
+			int(idle.Hours()), int(idle.Minutes())%60, int(idle.Seconds())%60,
+			simUsers[p.rand.IntN(len(simUsers))], simHosts[p.rand.IntN(len(simHosts))])
+	}
+
+	b.WriteString("--\n")
+	_, _ = fmt.Fprintf(&b, " %d connections; SSHD Backend: nio2\n", count)
+
+	return b.String()
+}
+
+var (
+	simProjects = []string{"platform/build", "platform/manifest", "platform/frameworks/base", "platform/prebuilts/clang"}
+	simUsers    = []string{"jdoe", "ci-bot", "release-bot", "asmith"}
+	simHosts    = []string{"ci1.example.com", "ci2.example.com", "dev.example.com"}
+)
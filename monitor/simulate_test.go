@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+func newSimulationMonitor(t *testing.T, scenario *Scenario) *Monitor {
+	t.Helper()
+
+	cfg := &config.Config{
+		Monitor: config.Monitor{
+			Timeout: 200 * time.Millisecond,
+		},
+	}
+
+	scenario.Seed = 1
+	scenario.Apply(cfg)
+
+	return NewSimulationMonitor(cfg, scenario)
+}
+
+func TestLoadScenario(t *testing.T) {
+	scenario, err := LoadScenario("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scenario.Sites) == 0 {
+		t.Fatal("built-in scenario has no sites")
+	}
+
+	m := newSimulationMonitor(t, scenario)
+
+	for _, site := range m.GetAllSitesStatus(context.Background()) {
+		if site.Name == "gerrit-xian" && site.Healthy {
+			t.Errorf("site %s healthy during outage", site.Name)
+		}
+		if site.Name == "gerrit-beijing" && !site.Healthy {
+			t.Errorf("site %s unhealthy: %s", site.Name, site.Error)
+		}
+	}
+}
+
+func TestScenarioApply(t *testing.T) {
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"gerrit_name":    {Location: "gerrit_location"},
+			"gerrit-beijing": {Location: "Beijing", Weight: 0.5},
+		},
+		Clusters: map[string]config.Cluster{
+			"android": {Sites: []string{"gerrit_name", "gerrit-beijing"}},
+			"kernel":  {Sites: []string{"gerrit_name"}},
+		},
+	}
+
+	scenario := &Scenario{
+		Sites: map[string]ScenarioSite{
+			"gerrit-beijing":  {Location: "Beijing, China", Weight: 0.8},
+			"gerrit-shanghai": {Location: "Shanghai, China"},
+		},
+	}
+
+	scenario.Apply(cfg)
+
+	if len(cfg.Gerrits) != 2 || cfg.Gerrits["gerrit-beijing"].Weight != 0.5 || cfg.Gerrits["gerrit-shanghai"].Location != "Shanghai, China" {
+		t.Errorf("sites = %v, want configured gerrit-beijing and simulated gerrit-shanghai", cfg.Gerrits)
+	}
+
+	if len(cfg.Clusters) != 1 || len(cfg.Clusters["android"].Sites) != 1 {
+		t.Errorf("clusters = %v, want android with gerrit-beijing only", cfg.Clusters)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	m := newSimulationMonitor(t, &Scenario{
+		Sites: map[string]ScenarioSite{
+			"busy": {
+				Latency:     Distribution{Min: 1, Max: 2},
+				Queue:       Distribution{Min: 40, Max: 40},
+				Connections: Distribution{Mean: 10, Stddev: 2, Min: 5, Max: 15},
+			},
+			"idle": {
+				Latency: Distribution{Min: 1, Max: 2},
+			},
+			"error": {
+				Outages: []Outage{{Mode: OutageError}},
+			},
+			"hang": {
+				Outages: []Outage{{Mode: OutageHang}},
+			},
+			"later": {
+				Outages: []Outage{{Start: time.Hour, Mode: OutageDown}},
+			},
+		},
+	})
+
+	ctx := context.Background()
+
+	busy := m.GetSiteStatus(ctx, "busy")
+	if !busy.Healthy || busy.QueueSize != 40 || busy.Connections < 5 || busy.Connections > 15 {
+		t.Errorf("busy = healthy %t, queue %d, connections %d", busy.Healthy, busy.QueueSize, busy.Connections)
+	}
+
+	for _, name := range []string{"error", "hang"} {
+		if site := m.GetSiteStatus(ctx, name); site.Healthy {
+			t.Errorf("site %s healthy during outage", name)
+		}
+	}
+
+	if site := m.GetSiteStatus(ctx, "later"); !site.Healthy {
+		t.Errorf("site later unhealthy before outage: %s", site.Error)
+	}
+
+	site, err := m.GetAvailableSite(ctx, SelectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if site.Name != "idle" && site.Name != "later" {
+		t.Errorf("available site = %s, want an idle site", site.Name)
+	}
+
+	queue, err := m.GetSiteQueues(ctx, "busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queue.Tasks) != 40 || queue.Replication == 0 {
+		t.Errorf("queue = %d tasks, %d replication", len(queue.Tasks), queue.Replication)
+	}
+}
+
+func TestOutage(t *testing.T) {
+	outage := Outage{Start: time.Minute, Duration: 10 * time.Second, Every: time.Minute}
+
+	tests := map[time.Duration]bool{
+		0:                              false,
+		time.Minute:                    true,
+		time.Minute + 9*time.Second:    true,
+		time.Minute + 10*time.Second:   false,
+		2*time.Minute + 5*time.Second:  true,
+		2*time.Minute + 30*time.Second: false,
+	}
+
+	for elapsed, want := range tests {
+		if got := outage.active(elapsed); got != want {
+			t.Errorf("active(%s) = %t, want %t", elapsed, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Smoothed struct {
+	ResponseTime float64 `json:"responseTime"`
+	Connections  float64 `json:"connections"`
+	Queue        float64 `json:"queue"`
+}
+
+// smoother keeps moving averages of the score inputs per site, and the current pick of the
+// selection hysteresis per group.
+type smoother struct {
+	averages map[string]*Smoothed
+	picks    map[string]*hysteresis
+	mutex    sync.Mutex
+}
+
+type hysteresis struct {
+	current    string
+	challenger string
+	since      time.Time
+}
+
+func newSmoother() *smoother {
+	return &smoother{
+		averages: make(map[string]*Smoothed),
+		picks:    make(map[string]*hysteresis),
+	}
+}
+
+// update adds a sample to the exponentially weighted moving averages of the site, which
+// start at the first sample.
+func (s *smoother) update(name string, alpha float64, sample Smoothed) Smoothed {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	average, found := s.averages[name]
+	if !found {
+		average = &sample
+		s.averages[name] = average
+		return *average
+	}
+
+	average.ResponseTime += alpha * (sample.ResponseTime - average.ResponseTime)
+	average.Connections += alpha * (sample.Connections - average.Connections)
+	average.Queue += alpha * (sample.Queue - average.Queue)
+
+	return *average
+}
+
+// reset drops the averages of an unhealthy site, so that it starts again from its first
+// sample on recovery.
+func (s *smoother) reset(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.averages, name)
+}
+
+// pick keeps the current site of the group first among the ranked sites, unless a competitor
+// beats it by margin percent of its score for the hold period, or it is no longer healthy.
+func (s *smoother) pick(group string, sites []*SiteStatus, margin float64, hold time.Duration, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, found := s.picks[group]
+	if !found {
+		h = &hysteresis{}
+		s.picks[group] = h
+	}
+
+	if len(sites) == 0 || !sites[0].Healthy {
+		h.current, h.challenger = "", ""
+		return
+	}
+
+	best := sites[0]
+
+	index := -1
+	for i, site := range sites {
+		if site.Name == h.current && site.Healthy {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 || index == 0 {
+		h.current, h.challenger = best.Name, ""
+		return
+	}
+
+	current := sites[index]
+
+	if float64(best.Score) < float64(current.Score)*(1-margin/100) {
+		if h.challenger != best.Name {
+			h.challenger, h.since = best.Name, now
+		}
+		if now.Sub(h.since) >= hold {
+			h.current, h.challenger = best.Name, ""
+			return
+		}
+	} else {
+		h.challenger = ""
+	}
+
+	copy(sites[1:index+1], sites[:index])
+	sites[0] = current
+}
+
+// smooth returns the score inputs averaged over the previous probes if smoothing is enabled.
+func (m *Monitor) smooth(name string, connections, queue int, latency time.Duration) (int, int, time.Duration, *Smoothed) {
+	alpha := m.config.Monitor.Smoothing.Alpha
+	if alpha <= 0 || alpha >= 1 {
+		return connections, queue, latency, nil
+	}
+
+	average := m.smoother.update(name, alpha, Smoothed{
+		ResponseTime: float64(latency.Milliseconds()),
+		Connections:  float64(connections),
+		Queue:        float64(queue),
+	})
+
+	return int(math.Round(average.Connections)),
+		int(math.Round(average.Queue)),
+		time.Duration(average.ResponseTime * float64(time.Millisecond)),
+		&average
+}
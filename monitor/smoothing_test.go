@@ -0,0 +1,109 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor/gerrittest"
+)
+
+func TestSmooth(t *testing.T) {
+	m := &Monitor{config: &config.Config{}, smoother: newSmoother()}
+
+	if connections, queue, latency, smoothed := m.smooth("beijing", 10, 20, 30*time.Millisecond); connections != 10 || queue != 20 ||
+		latency != 30*time.Millisecond || smoothed != nil {
+		t.Errorf("smoothing disabled = %d, %d, %s, %v, want samples", connections, queue, latency, smoothed)
+	}
+
+	m.config.Monitor.Smoothing.Alpha = 0.5
+
+	m.smooth("beijing", 10, 20, 100*time.Millisecond)
+
+	connections, queue, latency, smoothed := m.smooth("beijing", 0, 0, 0)
+	if connections != 5 || queue != 10 || latency != 50*time.Millisecond {
+		t.Errorf("smoothed = %d, %d, %s, want 5, 10, 50ms", connections, queue, latency)
+	}
+
+	if math.Abs(smoothed.ResponseTime-50) > 0.001 {
+		t.Errorf("smoothed response time = %f, want 50", smoothed.ResponseTime)
+	}
+
+	m.smoother.reset("beijing")
+
+	if connections, _, _, _ := m.smooth("beijing", 8, 0, 0); connections != 8 {
+		t.Errorf("connections after reset = %d, want 8", connections)
+	}
+}
+
+func TestSmoothCycleOnly(t *testing.T) {
+	m := newMonitor(t, map[string]*gerrittest.Server{
+		"beijing": newSite(t, "show-queue-3.9.txt", "show-connections-3.9.txt"),
+	})
+
+	m.config.Monitor.Smoothing.Alpha = 0.5
+
+	// Queries do not advance the averages
+	for i := 0; i < 3; i++ {
+		if status := m.GetSiteStatus(context.Background(), "beijing"); status.Smoothed != nil {
+			t.Fatalf("query smoothed = %+v, want nil", status.Smoothed)
+		}
+	}
+
+	if len(m.smoother.averages) != 0 {
+		t.Errorf("averages = %d after queries, want 0", len(m.smoother.averages))
+	}
+
+	sites := m.getSitesStatus(context.Background(), []string{"beijing"}, true)
+	if sites[0].Smoothed == nil || len(m.smoother.averages) != 1 {
+		t.Errorf("cycle smoothed = %+v, want averages", sites[0].Smoothed)
+	}
+}
+
+func TestPick(t *testing.T) {
+	s := newSmoother()
+	now := time.Now()
+
+	steps := []struct {
+		scores map[string]int
+		after  time.Duration
+		want   string
+	}{
+		{map[string]int{"beijing": 100, "shanghai": 110}, 0, "beijing"},
+		// Within the margin
+		{map[string]int{"beijing": 100, "shanghai": 90}, time.Minute, "beijing"},
+		// Beats the margin, but not for the hold period yet
+		{map[string]int{"beijing": 100, "shanghai": 50}, time.Second, "beijing"},
+		{map[string]int{"beijing": 100, "shanghai": 50}, 30 * time.Second, "beijing"},
+		// Falls back within the margin, which restarts the hold period
+		{map[string]int{"beijing": 100, "shanghai": 95}, time.Second, "beijing"},
+		{map[string]int{"beijing": 100, "shanghai": 50}, time.Second, "beijing"},
+		{map[string]int{"beijing": 100, "shanghai": 50}, 59 * time.Second, "beijing"},
+		{map[string]int{"beijing": 100, "shanghai": 50}, time.Second, "shanghai"},
+		// Switches immediately once unhealthy
+		{map[string]int{"beijing": 100, "shanghai": -1}, time.Second, "beijing"},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.after)
+		var sites []*SiteStatus
+		for name, score := range step.scores {
+			sites = append(sites, &SiteStatus{Name: name, Healthy: score >= 0, Score: score})
+		}
+		rankSites(sites)
+		s.pick("", sites, 20, time.Minute, now)
+		var names []string
+		for _, site := range sites {
+			names = append(names, site.Name)
+		}
+		if sites[0].Name != step.want {
+			t.Errorf("step %d: ranked %s, want %s first", i, strings.Join(names, ","), step.want)
+		}
+		if len(sites) != len(step.scores) {
+			t.Errorf("step %d: ranked %d sites, want %d", i, len(sites), len(step.scores))
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/utils"
+)
+
+const (
+	ThroughputInterval = 10 * time.Minute
+	ThroughputTimeout  = time.Minute
+
+	protocolHttp = "http"
+	protocolSsh  = "ssh"
+
+	// Reference size to turn ttfb and throughput into an estimated fetch time
+	referenceBytes = 1 << 20
+)
+
+type Throughput struct {
+	LsRemote       int64     `json:"lsRemote"`
+	Ttfb           int64     `json:"ttfb"`
+	Bytes          int64     `json:"bytes"`
+	BytesPerSecond int64     `json:"bytesPerSecond"`
+	LastCheck      time.Time `json:"lastCheck"`
+	Error          string    `json:"error"`
+}
+
+// getThroughput returns the last throughput of the site, nil if no canary repo is configured
+// or not measured yet. Selection never measures, see refreshThroughput.
+func (m *Monitor) getThroughput(name string) *Throughput {
+	if m.config.Monitor.Throughput.Repo == "" {
+		return nil
+	}
+
+	m.throughputMutex.Lock()
+	defer m.throughputMutex.Unlock()
+
+	return m.throughput[name]
+}
+
+// refreshThroughput measures the throughput of the healthy sites in the background once older
+// than the interval, with a single measurement in progress per site.
+func (m *Monitor) refreshThroughput(ctx context.Context, sites []*SiteStatus) {
+	if m.config.Monitor.Throughput.Repo == "" {
+		return
+	}
+
+	for _, site := range sites {
+		if site.Healthy && m.startThroughput(site.Name, time.Now()) {
+			go m.measureThroughput(ctx, site.Name)
+		}
+	}
+}
+
+// startThroughput reports whether the throughput of the site is due, and marks it in progress.
+func (m *Monitor) startThroughput(name string, now time.Time) bool {
+	interval := m.config.Monitor.Throughput.Interval
+	if interval <= 0 {
+		interval = ThroughputInterval
+	}
+
+	m.throughputMutex.Lock()
+	defer m.throughputMutex.Unlock()
+
+	if m.measuring[name] {
+		return false
+	}
+
+	if last, found := m.throughput[name]; found && now.Sub(last.LastCheck) < interval {
+		return false
+	}
+
+	m.measuring[name] = true
+
+	return true
+}
+
+// measureThroughput measures the throughput of the site within a probe slot, bounded by the
+// throughput timeout instead of the probe timeout.
+func (m *Monitor) measureThroughput(ctx context.Context, name string) *Throughput {
+	defer func() {
+		m.throughputMutex.Lock()
+		delete(m.measuring, name)
+		m.throughputMutex.Unlock()
+	}()
+
+	timeout := m.config.Monitor.Throughput.Timeout
+	if timeout <= 0 {
+		timeout = ThroughputTimeout
+	}
+
+	ctx, release, err := m.acquire(ctx, timeout)
+	if err != nil {
+		return nil
+	}
+
+	defer release()
+
+	result, err := m.prober.throughput(ctx, name)
+	if result == nil {
+		result = &Throughput{}
+	}
+
+	result.LastCheck = time.Now()
+
+	if err != nil {
+		slog.Warn("failed to probe throughput", "site", name, "error", err)
+		result.Error = err.Error()
+	}
+
+	m.throughputMutex.Lock()
+	m.throughput[name] = result
+	m.throughputMutex.Unlock()
+
+	return result
+}
+
+// getThroughputPenalty estimates the time to fetch 1 MiB from the site, with the same
+// 10ms = 1 point scale as the latency penalty.
+func (m *Monitor) getThroughputPenalty(throughput *Throughput) int {
+	weight := m.config.Monitor.Throughput.Weight
+	if throughput == nil || throughput.Error != "" || throughput.BytesPerSecond <= 0 || weight <= 0 {
+		return 0
+	}
+
+	estimate := float64(throughput.Ttfb) + float64(referenceBytes)/float64(throughput.BytesPerSecond)*1000
+
+	return int(estimate * 0.1 * weight)
+}
+
+// throughput runs git ls-remote and a shallow fetch of the canary repo from the site.
+func (p *sshProber) throughput(ctx context.Context, name string) (*Throughput, error) {
+	cfg := p.config.Monitor.Throughput
+	site := p.config.Gerrits[name]
+
+	url, env, err := p.gitRemote(ctx, site, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Throughput{}
+
+	start := time.Now()
+	if _, err := git(ctx, env, "ls-remote", url, "HEAD"); err != nil {
+		return nil, errors.Wrap(err, "failed to ls-remote")
+	}
+	result.LsRemote = time.Since(start).Milliseconds()
+
+	dir, err := os.MkdirTemp("", "proxy-throughput-")
+	if err != nil {
+		return result, err
+	}
+
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if _, err := git(ctx, env, "init", "--quiet", "--bare", dir); err != nil {
+		return result, errors.Wrap(err, "failed to init")
+	}
+
+	w := &firstByteWriter{start: time.Now()}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "fetch", "--depth=1", "--no-tags", "--progress", url, "HEAD")
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	if err := cmd.Run(); err != nil {
+		return result, errors.Wrapf(err, "failed to fetch: %s", strings.TrimSpace(w.last()))
+	}
+
+	elapsed := time.Since(w.start)
+
+	result.Ttfb = w.ttfb().Milliseconds()
+	result.Bytes = dirSize(filepath.Join(dir, "objects"))
+
+	if elapsed > 0 {
+		result.BytesPerSecond = int64(float64(result.Bytes) / elapsed.Seconds())
+	}
+
+	return result, nil
+}
+
+func (p *sshProber) gitRemote(ctx context.Context, site config.Gerrit, cfg config.Throughput) (string, []string, error) {
+	repo := strings.Trim(cfg.Repo, "/")
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+
+	switch cfg.Protocol {
+	case "", protocolHttp:
+		if site.Http.Url == "" {
+			return "", nil, errors.New("http url not configured")
+		}
+		if site.Http.User != "" {
+			password, err := site.Http.Password.Resolve(ctx)
+			if err != nil {
+				return "", nil, err
+			}
+			// Pass the credentials in the environment, not in the url or arguments seen by ps
+			token := base64.StdEncoding.EncodeToString([]byte(site.Http.User + ":" + password))
+			env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0=Authorization: Basic "+token)
+		}
+		return strings.TrimSuffix(site.Http.Url, "/") + "/" + repo, env, nil
+	case protocolSsh:
+		command := "ssh -o BatchMode=yes"
+		switch {
+		case site.Ssh.Key != "" && !site.Ssh.Key.IsReference() && site.Ssh.Passphrase == "":
+			command += fmt.Sprintf(" -i %q", utils.ExpandTilde(string(site.Ssh.Key)))
+		case os.Getenv(agentSocket) == "":
+			// ssh cannot read secret references nor prompt for passphrases in batch mode
+			return "", nil, errors.Errorf("ssh protocol needs a key file without passphrase or %s", agentSocket)
+		}
+		if p.config.Monitor.Insecure {
+			command += " -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+		} else if p.config.Monitor.KnownHosts != "" {
+			command += fmt.Sprintf(" -o UserKnownHostsFile=%q", utils.ExpandTilde(p.config.Monitor.KnownHosts))
+		}
+		url := fmt.Sprintf("ssh://%s@%s:%d/%s", site.Ssh.User, site.Ssh.Host, site.Ssh.Port, repo)
+		return url, append(env, "GIT_SSH_COMMAND="+command), nil
+	default:
+		return "", nil, errors.Errorf("invalid protocol %s", cfg.Protocol)
+	}
+}
+
+func git(ctx context.Context, env []string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(output), nil
+}
+
+func dirSize(name string) int64 {
+	var size int64
+
+	_ = filepath.WalkDir(name, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
+// firstByteWriter records when git prints its first progress from the remote.
+type firstByteWriter struct {
+	start time.Time
+	first time.Time
+	tail  []byte
+	mutex sync.Mutex
+}
+
+func (w *firstByteWriter) Write(buf []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.first.IsZero() && len(buf) != 0 {
+		w.first = time.Now()
+	}
+
+	w.tail = append(w.tail, buf...)
+	if len(w.tail) > 1024 {
+		w.tail = w.tail[len(w.tail)-1024:]
+	}
+
+	return len(buf), nil
+}
+
+func (w *firstByteWriter) ttfb() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.first.IsZero() {
+		return time.Since(w.start)
+	}
+
+	return w.first.Sub(w.start)
+}
+
+func (w *firstByteWriter) last() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return string(w.tail)
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+func TestThroughput(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "canary")
+
+	for _, args := range [][]string{
+		{"init", "--quiet", repo},
+		{"-C", repo, "-c", "user.name=proxy", "-c", "user.email=proxy@example.com", "commit", "--quiet", "--allow-empty", "-m", "canary"},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{
+			"local": {Http: config.Http{Url: "file://" + dir}},
+		},
+		Monitor: config.Monitor{
+			Throughput: config.Throughput{Repo: "canary", Weight: 1},
+		},
+	}
+
+	m := NewMonitor(cfg)
+
+	if got := m.getThroughput("local"); got != nil {
+		t.Errorf("throughput before measurement = %+v, want nil", got)
+	}
+
+	if !m.startThroughput("local", time.Now()) {
+		t.Fatal("first measurement not due")
+	}
+
+	// A single measurement in progress per site
+	if m.startThroughput("local", time.Now()) {
+		t.Error("measurement due while in progress")
+	}
+
+	throughput := m.measureThroughput(context.Background(), "local")
+	if throughput == nil || throughput.Error != "" {
+		t.Fatalf("throughput = %+v, want measured", throughput)
+	}
+
+	if throughput.Bytes <= 0 || throughput.BytesPerSecond <= 0 {
+		t.Errorf("bytes = %d, rate = %d, want positive", throughput.Bytes, throughput.BytesPerSecond)
+	}
+
+	if m.getThroughputPenalty(throughput) < 0 {
+		t.Errorf("negative penalty")
+	}
+
+	// Selection reads the last measurement, which is due again after the interval
+	if got := m.getThroughput("local"); got != throughput {
+		t.Errorf("throughput = %+v, want the last measurement", got)
+	}
+
+	if m.startThroughput("local", time.Now()) {
+		t.Error("measurement due within interval")
+	}
+
+	if !m.startThroughput("local", time.Now().Add(ThroughputInterval)) {
+		t.Error("measurement not due after interval")
+	}
+
+	cfg.Gerrits["missing"] = config.Gerrit{Http: config.Http{Url: "file://" + filepath.Join(dir, "missing")}}
+	cfg.Monitor.Throughput.Timeout = 10 * time.Second
+
+	if failed := m.measureThroughput(context.Background(), "missing"); failed == nil || failed.Error == "" {
+		t.Errorf("throughput = %+v, want error", failed)
+	}
+}
+
+func TestThroughputSlot(t *testing.T) {
+	cfg := &config.Config{
+		Gerrits: map[string]config.Gerrit{"local": {}},
+		Monitor: config.Monitor{
+			Concurrency: 1,
+			Throughput:  config.Throughput{Repo: "canary"},
+		},
+	}
+
+	m := NewMonitor(cfg)
+
+	// Measurements wait for a probe slot like probes
+	m.semaphore <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if got := m.measureThroughput(ctx, "local"); got != nil {
+		t.Errorf("throughput = %+v, want none without probe slot", got)
+	}
+
+	if !m.startThroughput("local", time.Now()) {
+		t.Error("measurement not due after cancellation")
+	}
+}
+
+func TestThroughputPenalty(t *testing.T) {
+	m := &Monitor{config: &config.Config{}}
+
+	throughput := &Throughput{Ttfb: 100, BytesPerSecond: 1 << 20}
+
+	if got := m.getThroughputPenalty(throughput); got != 0 {
+		t.Errorf("penalty without weight = %d, want 0", got)
+	}
+
+	m.config.Monitor.Throughput.Weight = 1
+
+	// 100ms ttfb + 1000ms for 1 MiB at 1 MiB/s
+	if got := m.getThroughputPenalty(throughput); got != 110 {
+		t.Errorf("penalty = %d, want 110", got)
+	}
+}
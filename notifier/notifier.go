@@ -0,0 +1,218 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+)
+
+const (
+	KindHealth = "health"
+	KindQueue  = "queue"
+	KindScore  = "score"
+
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+
+	defaultTemplate = "[{{.State}}] {{.Site}} {{.Kind}}: {{.Message}}"
+
+	queueSize   = 100
+	sendTimeout = 10 * time.Second
+)
+
+type Event struct {
+	Site    string              `json:"site"`
+	Kind    string              `json:"kind"`
+	State   string              `json:"state"`
+	Message string              `json:"message"`
+	Status  *monitor.SiteStatus `json:"status"`
+	Time    time.Time           `json:"time"`
+}
+
+type Notifier struct {
+	config  *config.Config
+	alerts  map[string]*alert
+	mutex   sync.Mutex
+	client  *http.Client
+	queue   chan *Event
+	pending sync.WaitGroup
+	start   sync.Once
+}
+
+type alert struct {
+	firing   bool
+	notified time.Time
+}
+
+func NewNotifier(cfg *config.Config) *Notifier {
+	return &Notifier{
+		config: cfg,
+		alerts: make(map[string]*alert),
+		client: &http.Client{Timeout: sendTimeout},
+		queue:  make(chan *Event, queueSize),
+	}
+}
+
+// Check evaluates the alerts of the sites and queues their events, which are sent in the
+// background so that slow receivers do not hold up the probe cycles.
+func (n *Notifier) Check(ctx context.Context, sites []*monitor.SiteStatus) {
+	var events []*Event
+
+	n.mutex.Lock()
+
+	now := time.Now()
+
+	for _, site := range sites {
+		events = n.evaluate(events, site, KindHealth, !site.Healthy, now,
+			fmt.Sprintf("site is unhealthy: %s", site.Error),
+			"site is healthy again")
+		// Unhealthy sites report sentinel values, their other alerts are kept as they are
+		if !site.Healthy {
+			continue
+		}
+		if n.config.Notify.Queue > 0 {
+			events = n.evaluate(events, site, KindQueue, site.QueueSize >= n.config.Notify.Queue, now,
+				fmt.Sprintf("queue size %d reached threshold %d", site.QueueSize, n.config.Notify.Queue),
+				fmt.Sprintf("queue size %d is below threshold %d", site.QueueSize, n.config.Notify.Queue))
+		}
+		if n.config.Notify.Score > 0 {
+			events = n.evaluate(events, site, KindScore, site.Score >= n.config.Notify.Score, now,
+				fmt.Sprintf("score %d reached threshold %d", site.Score, n.config.Notify.Score),
+				fmt.Sprintf("score %d is below threshold %d", site.Score, n.config.Notify.Score))
+		}
+	}
+
+	n.mutex.Unlock()
+
+	n.start.Do(func() {
+		go n.run(ctx)
+	})
+
+	for _, event := range events {
+		n.pending.Add(1)
+		select {
+		case n.queue <- event:
+		default:
+			n.pending.Done()
+			slog.Warn("notification queue full, event dropped", "site", event.Site, "kind", event.Kind, "state", event.State)
+		}
+	}
+}
+
+func (n *Notifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.queue:
+			n.Send(ctx, event)
+			n.pending.Done()
+		}
+	}
+}
+
+// flush waits for the queued events to be sent.
+func (n *Notifier) flush() {
+	n.pending.Wait()
+}
+
+func (n *Notifier) Send(ctx context.Context, event *Event) {
+	for i := range n.config.Notify.Receivers {
+		receiver := &n.config.Notify.Receivers[i]
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := n.send(sendCtx, receiver, event)
+		cancel()
+		if err != nil {
+			slog.Error("failed to notify", "receiver", receiver.Name, "site", event.Site, "kind", event.Kind, "error", err)
+			continue
+		}
+		slog.Info("notified", "receiver", receiver.Name, "site", event.Site, "kind", event.Kind, "state", event.State)
+	}
+}
+
+func (n *Notifier) evaluate(events []*Event, site *monitor.SiteStatus, kind string, active bool, now time.Time, firing, resolved string) []*Event {
+	key := site.Name + "/" + kind
+
+	a, found := n.alerts[key]
+	if !found {
+		a = &alert{}
+		n.alerts[key] = a
+	}
+
+	switch {
+	case active && !a.firing:
+		a.firing = true
+		a.notified = now
+		return append(events, newEvent(site, kind, StateFiring, firing, now))
+	case active && n.config.Notify.Renotify > 0 && now.Sub(a.notified) >= n.config.Notify.Renotify:
+		a.notified = now
+		return append(events, newEvent(site, kind, StateFiring, firing, now))
+	case !active && a.firing:
+		a.firing = false
+		return append(events, newEvent(site, kind, StateResolved, resolved, now))
+	}
+
+	return events
+}
+
+func (n *Notifier) send(ctx context.Context, receiver *config.Receiver, event *Event) error {
+	text, err := render(receiver, n.config.Notify.Template, event)
+	if err != nil {
+		return err
+	}
+
+	switch receiver.Type {
+	case "", "webhook":
+		return n.sendWebhook(ctx, receiver, event, text)
+	case "slack":
+		return n.sendSlack(ctx, receiver, text)
+	case "email":
+		return sendEmail(ctx, receiver, event, text)
+	default:
+		return errors.Errorf("invalid receiver type %s", receiver.Type)
+	}
+}
+
+func newEvent(site *monitor.SiteStatus, kind, state, message string, now time.Time) *Event {
+	return &Event{
+		Site:    site.Name,
+		Kind:    kind,
+		State:   state,
+		Message: message,
+		Status:  site,
+		Time:    now,
+	}
+}
+
+func render(receiver *config.Receiver, text string, event *Event) (string, error) {
+	if receiver.Template != "" {
+		text = receiver.Template
+	}
+
+	if text == "" {
+		text = defaultTemplate
+	}
+
+	tmpl, err := template.New(receiver.Name).Parse(text)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse template\n")
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", errors.Wrap(err, "failed to render template\n")
+	}
+
+	return buf.String(), nil
+}
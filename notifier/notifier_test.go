@@ -0,0 +1,281 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+)
+
+type payload struct {
+	Site  string `json:"site"`
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+	Text  string `json:"text"`
+}
+
+// newReceiver starts a webhook receiver and returns its config and the payloads received so far.
+func newReceiver(t *testing.T) (config.Receiver, func() []payload) {
+	t.Helper()
+
+	var received []payload
+	var mutex sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		received = append(received, p)
+		mutex.Unlock()
+	}))
+
+	t.Cleanup(server.Close)
+
+	receiver := config.Receiver{Name: "webhook", Type: "webhook", Url: config.Secret(server.URL)}
+
+	return receiver, func() []payload {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := received
+		received = nil
+		return result
+	}
+}
+
+func events(payloads []payload) []string {
+	var result []string
+
+	for _, p := range payloads {
+		result = append(result, p.Site+"/"+p.Kind+"/"+p.State)
+	}
+
+	return result
+}
+
+func TestCheckHealth(t *testing.T) {
+	receiver, received := newReceiver(t)
+
+	n := NewNotifier(&config.Config{
+		Notify: config.Notify{Receivers: []config.Receiver{receiver}},
+	})
+
+	ctx := context.Background()
+
+	steps := []struct {
+		healthy bool
+		want    string
+	}{
+		{true, ""},
+		{false, "beijing/health/firing"},
+		// Firing once without renotify
+		{false, ""},
+		{true, "beijing/health/resolved"},
+		{true, ""},
+		{false, "beijing/health/firing"},
+	}
+
+	for i, step := range steps {
+		n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Healthy: step.healthy, Error: "timeout"}})
+		n.flush()
+		if got := strings.Join(events(received()), ","); got != step.want {
+			t.Errorf("step %d: events = %q, want %q", i, got, step.want)
+		}
+	}
+}
+
+func TestCheckRenotify(t *testing.T) {
+	receiver, received := newReceiver(t)
+
+	n := NewNotifier(&config.Config{
+		Notify: config.Notify{Renotify: time.Hour, Receivers: []config.Receiver{receiver}},
+	})
+
+	ctx := context.Background()
+	sites := []*monitor.SiteStatus{{Name: "beijing", Error: "timeout"}}
+
+	n.Check(ctx, sites)
+	n.Check(ctx, sites)
+	n.flush()
+
+	if got := events(received()); len(got) != 1 {
+		t.Fatalf("events = %v, want a single firing within renotify", got)
+	}
+
+	n.alerts["beijing/"+KindHealth].notified = time.Now().Add(-time.Hour)
+
+	n.Check(ctx, sites)
+	n.flush()
+
+	got := received()
+	if len(got) != 1 || got[0].State != StateFiring {
+		t.Errorf("events = %v, want firing again after renotify", events(got))
+	}
+
+	if want := "[firing] beijing health: site is unhealthy: timeout"; len(got) == 1 && got[0].Text != want {
+		t.Errorf("text = %q, want %q", got[0].Text, want)
+	}
+}
+
+func TestCheckQueue(t *testing.T) {
+	receiver, received := newReceiver(t)
+
+	n := NewNotifier(&config.Config{
+		Notify: config.Notify{Queue: 100, Receivers: []config.Receiver{receiver}},
+	})
+
+	ctx := context.Background()
+
+	steps := []struct {
+		healthy bool
+		queue   int
+		want    string
+	}{
+		{true, 99, ""},
+		{true, 100, "beijing/queue/firing"},
+		{true, 150, ""},
+		{true, 20, "beijing/queue/resolved"},
+		// Unreachable sites report the queue sentinel, which only fires the health alert
+		{false, monitor.QueueMax, "beijing/health/firing"},
+	}
+
+	for i, step := range steps {
+		n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Healthy: step.healthy, QueueSize: step.queue}})
+		n.flush()
+		if got := strings.Join(events(received()), ","); got != step.want {
+			t.Errorf("step %d: events = %q, want %q", i, got, step.want)
+		}
+	}
+}
+
+func TestCheckQueueUnhealthy(t *testing.T) {
+	receiver, received := newReceiver(t)
+
+	n := NewNotifier(&config.Config{
+		Notify: config.Notify{Queue: 100, Score: 1000, Receivers: []config.Receiver{receiver}},
+	})
+
+	ctx := context.Background()
+
+	n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Healthy: true, QueueSize: 150, Score: 1500}})
+	n.flush()
+
+	if got := strings.Join(events(received()), ","); got != "beijing/queue/firing,beijing/score/firing" {
+		t.Fatalf("events = %q, want queue and score firing", got)
+	}
+
+	// The queue and score alerts are kept while the site is down, without quoting its sentinels
+	n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Error: "timeout", QueueSize: monitor.QueueMax, Score: -1}})
+	n.flush()
+
+	if got := strings.Join(events(received()), ","); got != "beijing/health/firing" {
+		t.Errorf("unhealthy events = %q, want health firing only", got)
+	}
+
+	n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Healthy: true, QueueSize: 20, Score: 30}})
+	n.flush()
+
+	got := received()
+	if strings.Join(events(got), ",") != "beijing/health/resolved,beijing/queue/resolved,beijing/score/resolved" {
+		t.Fatalf("healthy events = %v, want all resolved", events(got))
+	}
+
+	if want := "[resolved] beijing queue: queue size 20 is below threshold 100"; got[1].Text != want {
+		t.Errorf("text = %q, want %q", got[1].Text, want)
+	}
+}
+
+func TestCheckHangingReceiver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	// The smtp server accepts connections and never answers
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	receiver := config.Receiver{
+		Name: "email",
+		Type: "email",
+		Smtp: config.Smtp{Host: addr.IP.String(), Port: addr.Port, From: "proxy@example.com", To: []string{"ops@example.com"}},
+	}
+
+	n := NewNotifier(&config.Config{
+		Notify: config.Notify{Receivers: []config.Receiver{receiver}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		n.Check(ctx, []*monitor.SiteStatus{{Name: "beijing", Error: "timeout"}})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("check blocked on the smtp server")
+	}
+
+	timeout, stop := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer stop()
+
+	start := time.Now()
+
+	if err := sendEmail(timeout, &receiver, &Event{Site: "beijing"}, "test"); err == nil {
+		t.Error("email to a hanging server sent, want timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("email gave up after %s, want the deadline of the context", elapsed)
+	}
+}
+
+func TestPostRedactsUrl(t *testing.T) {
+	server := httptest.NewServer(nil)
+	server.Close()
+
+	n := NewNotifier(&config.Config{})
+
+	for _, secret := range []config.Secret{
+		config.Secret(server.URL + "/services/T0000/B0000/token"),
+		"http://[::1:token",
+	} {
+		err := n.post(context.Background(), secret, map[string]string{"text": "test"})
+		if err == nil {
+			t.Fatalf("post to %s succeeded, want error", secret)
+		}
+		if strings.Contains(err.Error(), "token") {
+			t.Errorf("error leaks the url: %v", err)
+		}
+	}
+}
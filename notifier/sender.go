@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/repo-scm/proxy/config"
+)
+
+func (n *Notifier) sendWebhook(ctx context.Context, receiver *config.Receiver, event *Event, text string) error {
+	payload := struct {
+		*Event
+		Text string `json:"text"`
+	}{
+		Event: event,
+		Text:  text,
+	}
+
+	return n.post(ctx, receiver.Url, payload)
+}
+
+func (n *Notifier) sendSlack(ctx context.Context, receiver *config.Receiver, text string) error {
+	payload := map[string]string{
+		"text": text,
+	}
+
+	return n.post(ctx, receiver.Url, payload)
+}
+
+// post sends the payload to the url of the secret, which is redacted from errors since the url
+// of webhooks holds their token.
+func (n *Notifier) post(ctx context.Context, secret config.Secret, payload interface{}) error {
+	target, err := secret.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(buf))
+	if err != nil {
+		return errors.Errorf("invalid url %s", secret)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return errors.Errorf("%s %s: %v", urlErr.Op, secret, urlErr.Err)
+		}
+		return err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func sendEmail(ctx context.Context, receiver *config.Receiver, event *Event, text string) error {
+	var auth smtp.Auth
+
+	cfg := receiver.Smtp
+
+	if cfg.Host == "" || len(cfg.To) == 0 {
+		return errors.New("invalid smtp config")
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+
+	if cfg.User != "" {
+		password, err := cfg.Password.Resolve(ctx)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.User, password, cfg.Host)
+	}
+
+	from := cfg.From
+	if from == "" {
+		from = cfg.User
+	}
+
+	subject := fmt.Sprintf("[proxy] %s %s %s", event.Site, event.Kind, event.State)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, strings.Join(cfg.To, ", "), subject, text)
+
+	return sendMail(ctx, net.JoinHostPort(cfg.Host, strconv.Itoa(port)), cfg.Host, auth, from, cfg.To, []byte(msg))
+}
+
+// sendMail is smtp.SendMail with the connection bound to the deadline of the context, so that a
+// hanging smtp server cannot block the notifications.
+func sendMail(ctx context.Context, addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -43,7 +43,7 @@ if [[ -n "${ret}" ]]; then
 fi
 
 # Set location to chengdu
-ret=$(go run test.go --sites "10.75.200.210" | tail -n 1 | sed 's/^best site: //')
+ret=$(eval "$(proxy bench --rounds 1 --sites "10.75.200.210" --format env)" && echo "${BENCH_BEST}")
 if [[ -n "${ret}" ]]; then
     echo "LOCAL_GERRIT=${ret}"
     export LOCAL_GERRIT="${ret}"
@@ -51,7 +51,7 @@ if [[ -n "${ret}" ]]; then
 fi
 
 # Set location to shanghai
-ret=$(go run test.go --sites "10.67.40.202,10.63.237.206,10.67.16.29" | tail -n 1 | sed 's/^best site: //')
+ret=$(eval "$(proxy bench --rounds 1 --sites "10.67.40.202,10.63.237.206,10.67.16.29" --format env)" && echo "${BENCH_BEST}")
 if [[ -n "${ret}" ]]; then
     echo "LOCAL_GERRIT=${ret}"
     export LOCAL_GERRIT="${ret}"
@@ -59,7 +59,7 @@ if [[ -n "${ret}" ]]; then
 fi
 
 # Set location to xian
-ret=$(go run test.go --sites "10.95.243.159,10.95.243.158" | tail -n 1 | sed 's/^best site: //')
+ret=$(eval "$(proxy bench --rounds 1 --sites "10.95.243.159,10.95.243.158" --format env)" && echo "${BENCH_BEST}")
 if [[ -n "${ret}" ]]; then
     echo "LOCAL_GERRIT=${ret}"
     export LOCAL_GERRIT="${ret}"
@@ -3,8 +3,12 @@ package server
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -13,12 +17,20 @@ import (
 	"github.com/repo-scm/proxy/monitor"
 )
 
+const (
+	sessionHeader = "X-Proxy-Session"
+)
+
 //go:embed templates/index.html
 var templateFS embed.FS
 
+var startTime = time.Now()
+
 type Server struct {
-	config  *config.Config
-	monitor *monitor.Monitor
+	config    *config.Config
+	monitor   *monitor.Monitor
+	buildTime string
+	commitID  string
 }
 
 func NewServer(cfg *config.Config) *Server {
@@ -28,21 +40,34 @@ func NewServer(cfg *config.Config) *Server {
 	}
 }
 
-func NewTestServer(cfg *config.Config) *Server {
+func NewSimulationServer(cfg *config.Config, scenario *monitor.Scenario) *Server {
 	return &Server{
 		config:  cfg,
-		monitor: monitor.NewTestMonitor(cfg),
+		monitor: monitor.NewSimulationMonitor(cfg, scenario),
 	}
 }
 
+func (s *Server) SetVersion(buildTime, commitID string) {
+	s.buildTime = buildTime
+	s.commitID = commitID
+}
+
+func (s *Server) Monitor() *monitor.Monitor {
+	return s.monitor
+}
+
 func (s *Server) Handler() http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/ui", s.handleUI).Methods("GET")
 	r.HandleFunc("/ui/", s.handleUI).Methods("GET")
+	r.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
 
 	api := r.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/status", s.handleAPIStatus).Methods("GET")
+	api.HandleFunc("/select", s.handleAPISelect).Methods("GET")
+	api.HandleFunc("/groups/{group}/select", s.handleAPISelect).Methods("GET")
 	api.HandleFunc("/sites", s.handleAPISites).Methods("GET")
 	api.HandleFunc("/sites/{site}/health", s.handleAPISiteHealth).Methods("GET")
 	api.HandleFunc("/sites/{site}/queues", s.handleAPISiteQueues).Methods("GET")
@@ -50,7 +75,8 @@ func (s *Server) Handler() http.Handler {
 
 	r.Use(corsMiddleware)
 
-	return r
+	// Wrap the router rather than using it, so that unmatched routes are logged too
+	return logMiddleware(r)
 }
 
 func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
@@ -68,11 +94,70 @@ func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	stats := s.monitor.GetStats()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if stats.Cycles == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "waiting for first probe cycle",
+		})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	})
+}
+
 func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+
+	runtime.ReadMemStats(&mem)
+
+	stats := s.monitor.GetStats()
+
 	status := map[string]interface{}{
 		"timestamp": time.Now(),
-		"version":   "1.0.0",
-		"uptime":    time.Since(time.Now()).String(),
+		"version":   s.buildTime + "-" + s.commitID,
+		"buildTime": s.buildTime,
+		"commitID":  s.commitID,
+		"startTime": startTime,
+		"uptime":    time.Since(startTime).String(),
+		"config": map[string]interface{}{
+			"path": s.config.Path,
+			"hash": s.config.Hash,
+		},
+		"sites": map[string]interface{}{
+			"total":     len(s.config.Gerrits),
+			"healthy":   stats.Healthy,
+			"unhealthy": stats.Unhealthy,
+			"unknown":   stats.Unknown,
+		},
+		"monitor": map[string]interface{}{
+			"cycles":        stats.Cycles,
+			"lastCycle":     stats.LastCycle,
+			"cycleDuration": stats.CycleDuration.String(),
+			"lag":           stats.Lag.String(),
+			"interval":      stats.Interval.String(),
+		},
+		"runtime": map[string]interface{}{
+			"go":         runtime.Version(),
+			"goroutines": runtime.NumGoroutine(),
+			"cpus":       runtime.NumCPU(),
+			"heapAlloc":  mem.HeapAlloc,
+			"sys":        mem.Sys,
+			"numGC":      mem.NumGC,
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -80,17 +165,67 @@ func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleAPISites(w http.ResponseWriter, r *http.Request) {
-	sites := s.monitor.GetAllSitesStatus()
+	sites := s.monitor.GetAllSitesStatus(r.Context())
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(sites)
 }
 
+func (s *Server) handleAPISelect(w http.ResponseWriter, r *http.Request) {
+	var data interface{}
+	var err error
+
+	w.Header().Set("Content-Type", "application/json")
+
+	opts := monitor.SelectOptions{
+		AllowDegraded: r.URL.Query().Get("degraded") == "true",
+		Session:       r.URL.Query().Get("session"),
+		Group:         mux.Vars(r)["group"],
+	}
+
+	if opts.Session == "" {
+		opts.Session = r.Header.Get(sessionHeader)
+	}
+
+	if top := r.URL.Query().Get("top"); top != "" {
+		n, e := strconv.Atoi(top)
+		if e != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid top "+top)
+			return
+		}
+		opts.Peek = true
+		data, err = s.monitor.GetRankedSites(r.Context(), n, opts)
+	} else {
+		data, err = s.monitor.GetAvailableSite(r.Context(), opts)
+	}
+
+	if err != nil {
+		var groupErr *monitor.GroupNotFoundError
+		if errors.As(err, &groupErr) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		var noHealthyErr *monitor.NoHealthySiteError
+		if errors.As(err, &noHealthyErr) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   err.Error(),
+				"reasons": noHealthyErr.Reasons,
+			})
+			return
+		}
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(data)
+}
+
 func (s *Server) handleAPISiteHealth(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	siteName := vars["site"]
 
-	health := s.monitor.GetSiteHealth(siteName)
+	health := s.monitor.GetSiteHealth(r.Context(), siteName)
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(health)
@@ -100,9 +235,14 @@ func (s *Server) handleAPISiteQueues(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	siteName := vars["site"]
 
-	queues := s.monitor.GetSiteQueues(siteName)
-
 	w.Header().Set("Content-Type", "application/json")
+
+	queues, err := s.monitor.GetSiteQueues(r.Context(), siteName)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
 	_ = json.NewEncoder(w).Encode(queues)
 }
 
@@ -110,17 +250,71 @@ func (s *Server) handleAPISiteConnections(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	siteName := vars["site"]
 
-	connections := s.monitor.GetSiteConnections(siteName)
-
 	w.Header().Set("Content-Type", "application/json")
+
+	connections, err := s.monitor.GetSiteConnections(r.Context(), siteName)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
 	_ = json.NewEncoder(w).Encode(connections)
 }
 
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": msg,
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func logMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"remote", r.RemoteAddr,
+			"status", sw.status,
+			"size", sw.size,
+			"duration", time.Since(start))
+	})
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+sessionHeader)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
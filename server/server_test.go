@@ -1 +1,209 @@
 package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/repo-scm/proxy/config"
+	"github.com/repo-scm/proxy/monitor"
+)
+
+// newServer serves a healthy beijing site and a xian site which is down, the only site of the
+// kernel cluster.
+func newServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	scenario := &monitor.Scenario{
+		Seed: 1,
+		Sites: map[string]monitor.ScenarioSite{
+			"gerrit-beijing": {
+				Host:    "10.67.16.29",
+				Latency: monitor.Distribution{Min: 1, Max: 2},
+			},
+			"gerrit-xian": {
+				Host:    "10.95.243.159",
+				Outages: []monitor.Outage{{Mode: monitor.OutageDown}},
+			},
+		},
+		Clusters: map[string]config.Cluster{
+			"kernel": {Sites: []string{"gerrit-xian"}},
+		},
+	}
+
+	cfg := &config.Config{
+		Path:    "proxy.yaml",
+		Hash:    "0123456789ab",
+		Monitor: config.Monitor{Interval: time.Hour},
+	}
+
+	scenario.Apply(cfg)
+
+	s := NewSimulationServer(cfg, scenario)
+
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	return s, ts
+}
+
+// runCycle starts the monitor and waits for its first probe cycle.
+func runCycle(t *testing.T, s *Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go s.Monitor().Run(ctx, nil)
+
+	for s.Monitor().GetStats().Cycles == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func get(t *testing.T, url string, data interface{}) int {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if err := json.NewDecoder(resp.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode
+}
+
+func TestHealthz(t *testing.T) {
+	_, ts := newServer(t)
+
+	var data map[string]string
+
+	if code := get(t, ts.URL+"/healthz", &data); code != http.StatusOK || data["status"] != "ok" {
+		t.Errorf("healthz = %d %v, want 200 ok", code, data)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s, ts := newServer(t)
+
+	var data map[string]string
+
+	if code := get(t, ts.URL+"/readyz", &data); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before the first cycle = %d %v, want 503", code, data)
+	}
+
+	runCycle(t, s)
+
+	if code := get(t, ts.URL+"/readyz", &data); code != http.StatusOK || data["status"] != "ok" {
+		t.Errorf("readyz after the first cycle = %d %v, want 200 ok", code, data)
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	s, ts := newServer(t)
+
+	runCycle(t, s)
+
+	var data struct {
+		Config struct {
+			Hash string `json:"hash"`
+		} `json:"config"`
+		Sites struct {
+			Total     int `json:"total"`
+			Healthy   int `json:"healthy"`
+			Unhealthy int `json:"unhealthy"`
+		} `json:"sites"`
+		Monitor struct {
+			Cycles int `json:"cycles"`
+		} `json:"monitor"`
+	}
+
+	if code := get(t, ts.URL+"/api/status", &data); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+
+	if data.Config.Hash != "0123456789ab" {
+		t.Errorf("config hash = %q, want 0123456789ab", data.Config.Hash)
+	}
+
+	if data.Sites.Total != 2 || data.Sites.Healthy != 1 || data.Sites.Unhealthy != 1 {
+		t.Errorf("sites = %+v, want 2 sites, 1 healthy and 1 unhealthy", data.Sites)
+	}
+
+	if data.Monitor.Cycles != 1 {
+		t.Errorf("cycles = %d, want 1", data.Monitor.Cycles)
+	}
+}
+
+func TestAPIGroupSelect(t *testing.T) {
+	s, ts := newServer(t)
+
+	runCycle(t, s)
+
+	var data struct {
+		Name    string            `json:"name"`
+		Error   string            `json:"error"`
+		Reasons map[string]string `json:"reasons"`
+	}
+
+	if code := get(t, ts.URL+"/api/select", &data); code != http.StatusOK || data.Name != "gerrit-beijing" {
+		t.Errorf("select = %d %+v, want 200 gerrit-beijing", code, data)
+	}
+
+	if code := get(t, ts.URL+"/api/groups/android/select", &data); code != http.StatusNotFound || data.Error != "group android not found" {
+		t.Errorf("unknown group = %d %+v, want 404 group android not found", code, data)
+	}
+
+	data.Reasons = nil
+
+	if code := get(t, ts.URL+"/api/groups/kernel/select", &data); code != http.StatusServiceUnavailable {
+		t.Errorf("kernel select = %d %+v, want 503", code, data)
+	}
+
+	if len(data.Reasons) != 1 || data.Reasons["gerrit-xian"] == "" {
+		t.Errorf("kernel reasons = %v, want the reason of gerrit-xian only", data.Reasons)
+	}
+}
+
+func TestLogUnmatched(t *testing.T) {
+	_, ts := newServer(t)
+
+	var buf bytes.Buffer
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+	})
+
+	for _, request := range [][]string{{http.MethodGet, "/api/missing"}, {http.MethodPost, "/healthz"}} {
+		req, err := http.NewRequest(request[0], ts.URL+request[1], http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	for _, want := range []string{"method=GET path=/api/missing", "status=404", "method=POST path=/healthz", "status=405"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log misses %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatTable = "table"
+	FormatJson  = "json"
+	FormatYaml  = "yaml"
+	FormatCsv   = "csv"
+	FormatEnv   = "env"
+)
+
+type Format struct {
+	Header []string
+	Rows   [][]string
+	Env    [][]string
+	Data   interface{}
+}
+
+func IsTemplate(format string) bool {
+	return strings.Contains(format, "{{")
+}
+
+func ValidFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJson, FormatYaml, FormatCsv, FormatEnv:
+		return nil
+	}
+
+	if IsTemplate(format) {
+		if _, err := template.New("format").Parse(format); err != nil {
+			return errors.Wrap(err, "invalid format template\n")
+		}
+		return nil
+	}
+
+	return errors.Errorf("invalid format %s (table|json|yaml|csv|env|template)", format)
+}
+
+func WriteFormat(_ context.Context, w io.Writer, format string, data *Format) error {
+	switch format {
+	case FormatTable:
+		return writeTable(w, append([][]string{data.Header}, data.Rows...))
+	case FormatJson:
+		return writeJson(w, data.Data)
+	case FormatYaml:
+		return writeYaml(w, data.Data)
+	case FormatCsv:
+		return writeCsv(w, append([][]string{data.Header}, data.Rows...))
+	case FormatEnv:
+		return writeEnv(w, data.Env)
+	}
+
+	if IsTemplate(format) {
+		return writeTemplate(w, format, data.Data)
+	}
+
+	return ValidFormat(format)
+}
+
+func writeJson(w io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(data)
+}
+
+func writeYaml(w io.Writer, data interface{}) error {
+	var node yaml.Node
+
+	// Round-trip through JSON so that yaml keys follow the json tags and field order
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return err
+	}
+
+	resetStyle(&node)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, item := range node.Content {
+		resetStyle(item)
+	}
+}
+
+func writeCsv(w io.Writer, data [][]string) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.WriteAll(data); err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
+
+func writeEnv(w io.Writer, data [][]string) error {
+	for _, item := range data {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", item[0], quoteEnv(item[1])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func quoteEnv(value string) string {
+	if value != "" && strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/@+=%") == "" {
+		return value
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func writeTemplate(w io.Writer, format string, data interface{}) error {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return errors.Wrap(err, "invalid format template\n")
+	}
+
+	items := reflect.ValueOf(data)
+	if items.Kind() != reflect.Slice {
+		items = reflect.ValueOf([]interface{}{data})
+	}
+
+	for i := 0; i < items.Len(); i++ {
+		if err := tmpl.Execute(w, items.Index(i).Interface()); err != nil {
+			return errors.Wrap(err, "failed to execute format template\n")
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
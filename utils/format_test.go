@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+type formatSite struct {
+	Name  string `json:"name"`
+	Host  string `json:"host"`
+	Score int    `json:"score"`
+}
+
+func testFormat() *Format {
+	sites := []*formatSite{
+		{Name: "gerrit-beijing", Host: "10.67.16.29", Score: 12},
+		{Name: "gerrit-xian", Host: "10.95.243.159", Score: 120},
+	}
+
+	return &Format{
+		Header: []string{"NAME", "HOST", "SCORE"},
+		Rows: [][]string{
+			{"gerrit-beijing", "10.67.16.29", "12"},
+			{"gerrit-xian", "10.95.243.159", "120"},
+		},
+		Env: [][]string{
+			{"LOCAL_GERRIT", "10.67.16.29"},
+			{"LOCAL_GERRIT_LOCATION", "Beijing, China"},
+			{"LOCAL_GERRIT_NOTE", "it's"},
+			{"LOCAL_GERRIT_EMPTY", ""},
+		},
+		Data: sites,
+	}
+}
+
+func TestWriteFormat(t *testing.T) {
+	tests := map[string]string{
+		FormatJson: `[
+  {
+    "name": "gerrit-beijing",
+    "host": "10.67.16.29",
+    "score": 12
+  },
+  {
+    "name": "gerrit-xian",
+    "host": "10.95.243.159",
+    "score": 120
+  }
+]
+`,
+		FormatYaml: `- name: gerrit-beijing
+  host: 10.67.16.29
+  score: 12
+- name: gerrit-xian
+  host: 10.95.243.159
+  score: 120
+`,
+		FormatCsv: `NAME,HOST,SCORE
+gerrit-beijing,10.67.16.29,12
+gerrit-xian,10.95.243.159,120
+`,
+		FormatEnv: `LOCAL_GERRIT=10.67.16.29
+LOCAL_GERRIT_LOCATION='Beijing, China'
+LOCAL_GERRIT_NOTE='it'\''s'
+LOCAL_GERRIT_EMPTY=''
+`,
+		"{{.Name}} {{.Host}}": `gerrit-beijing 10.67.16.29
+gerrit-xian 10.95.243.159
+`,
+	}
+
+	for format, want := range tests {
+		var buf bytes.Buffer
+		if err := WriteFormat(context.Background(), &buf, format, testFormat()); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if buf.String() != want {
+			t.Errorf("%s = %q, want %q", format, buf.String(), want)
+		}
+	}
+}
+
+func TestWriteFormatTable(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteFormat(context.Background(), &buf, FormatTable, testFormat()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("table = %d lines, want 6:\n%s", len(lines), buf.String())
+	}
+
+	for i, want := range [][]string{1: {"NAME", "HOST", "SCORE"}, 3: {"gerrit-beijing", "10.67.16.29", "12"}, 4: {"gerrit-xian", "10.95.243.159", "120"}} {
+		for _, cell := range want {
+			if !strings.Contains(lines[i], cell) {
+				t.Errorf("table line %d misses %q:\n%s", i, cell, buf.String())
+			}
+		}
+	}
+}
+
+func TestWriteFormatTemplateSingle(t *testing.T) {
+	data := testFormat()
+	data.Data = &formatSite{Name: "gerrit-beijing", Host: "10.67.16.29"}
+
+	var buf bytes.Buffer
+
+	if err := WriteFormat(context.Background(), &buf, "{{.Host}}", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "10.67.16.29\n" {
+		t.Errorf("template = %q, want the host", buf.String())
+	}
+}
+
+func TestValidFormat(t *testing.T) {
+	for _, format := range []string{FormatTable, FormatJson, FormatYaml, FormatCsv, FormatEnv, "{{.Host}}"} {
+		if err := ValidFormat(format); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+
+	for _, format := range []string{"xml", "{{.Host", ""} {
+		if err := ValidFormat(format); err == nil {
+			t.Errorf("%q valid, want error", format)
+		}
+		if err := WriteFormat(context.Background(), &bytes.Buffer{}, format, testFormat()); err == nil {
+			t.Errorf("%q written, want error", format)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"context"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,8 +29,22 @@ func ExpandTilde(name string) string {
 	return filepath.Join(homeDir, name[1:])
 }
 
+// RedactUrl hides the password of the url.
+func RedactUrl(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+
+	return u.Redacted()
+}
+
 func WriteTable(_ context.Context, data [][]string) error {
-	table := tablewriter.NewWriter(os.Stdout)
+	return writeTable(os.Stdout, data)
+}
+
+func writeTable(w io.Writer, data [][]string) error {
+	table := tablewriter.NewWriter(w)
 
 	table.Header(data[0])
 	_ = table.Bulk(data[1:])